@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Returns the user ids on LoginSite listed, separated by commas, in the environment variable named
+func listedIDs(variable string) []int {
+	ids := []int{}
+	for _, field := range strings.FieldsFunc(os.Getenv(variable), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if id, err := strconv.Atoi(field); err == nil && id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Returns true if the user with userID on LoginSite is an admin, listed in the STACKTRACKER_ADMINS environment variable
+func IsAdmin(userID int) bool {
+	return containsInt(listedIDs("STACKTRACKER_ADMINS"), userID)
+}
+
+// Returns the ids on LoginSite of the team: the members listed in the STACKTRACKER_TEAM environment variable, and the admins
+// Only team members can be assigned questions, are credited with answers and can read the team's notes.
+func TeamIDs() []int {
+	ids := listedIDs("STACKTRACKER_TEAM")
+	for _, id := range listedIDs("STACKTRACKER_ADMINS") {
+		if !containsInt(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Returns true if the user with userID on LoginSite is on the team
+func IsTeamMember(userID int) bool {
+	return containsInt(TeamIDs(), userID)
+}
+
+// Returns a condition matching rows whose column holds the id of a team member, and its arguments
+// Nothing matches while the team is empty
+func TeamIn(column string) (string, []interface{}) {
+	team := TeamIDs()
+	if len(team) == 0 {
+		return "FALSE", nil
+	}
+	args := make([]interface{}, len(team))
+	for i, id := range team {
+		args[i] = id
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(team)-1) + ")", args
+}
+
+// Records a team member's account on every StackExchange site, so their answers are recognised wherever they post
+// userID is their id on LoginSite, and accountID the id of their network account, or 0 if it is not known.
+func SaveUserAccounts(db *sql.DB, ctx context.Context, userID int, accountID int) error {
+	stmt, err := db.Prepare("INSERT INTO user_account(site, account_id, user_id) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE user_id=VALUES(user_id)")
+	if err != nil {
+		return fmt.Errorf("Prepare failed: %v", err.Error())
+	}
+	defer stmt.Close()
+
+	// The account on the login site is known without asking, so it is kept even if the others cannot be fetched
+	if _, err := stmt.Exec(LoginSite, userID, userID); err != nil {
+		return fmt.Errorf("Account insertion failed: %v", err.Error())
+	}
+	if accountID == 0 {
+		return nil
+	}
+
+	accounts, err := dataCollect.GetAssociatedAccounts(client, []int{accountID}, appInfo, make(stackongo.Params))
+	if err != nil {
+		return fmt.Errorf("Associated account request failed: %v", err.Error())
+	}
+	for _, account := range accounts.Items {
+		if _, err := stmt.Exec(SiteName(account.Site_url), account.User_id, userID); err != nil {
+			return fmt.Errorf("Account insertion failed: %v", err.Error())
+		}
+	}
+	applog.Infof(ctx, "%v accounts saved for user %v", len(accounts.Items), userID)
+	return nil
+}
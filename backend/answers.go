@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Returns the ids of the questions from site in the db filtered by params
+func questionIDs(db *sql.DB, site string, params string) ([]int, error) {
+	ids := []int{}
+	query := "SELECT question_id FROM questions WHERE site=?"
+	if params != "" {
+		query += " AND " + params
+	}
+	rows, err := db.Query(query, site)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+	var id int
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Returns the answers to the questions with ids on site from StackExchange
+func GetAnswers(site string, ids []int) (*stackongo.Answers, error) {
+	params := make(stackongo.Params)
+	params.Pagesize(100)
+	params.Sort("creation")
+	params.Add("site", site)
+
+	return dataCollect.GetAnswersByQuestionIDs(client, ids, appInfo, params)
+}
+
+// Adds answers from site into the database, updating the score and accepted state of answers already stored
+// StackExchange does not say when an answer was accepted, so the time it was first seen accepted is kept instead.
+func AddAnswers(db *sql.DB, ctx context.Context, site string, answers []stackongo.Answer) error {
+	stmt, err := db.Prepare("INSERT INTO answers(site, answer_id, question_id, user_id, user_name, creation_date, score, is_accepted, accepted_date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE user_name=VALUES(user_name), score=VALUES(score), is_accepted=VALUES(is_accepted), " +
+		"accepted_date=IF(VALUES(is_accepted)=1, COALESCE(accepted_date, VALUES(accepted_date)), NULL)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, answer := range answers {
+		var accepted sql.NullInt64
+		if answer.Is_accepted {
+			accepted = sql.NullInt64{Int64: now, Valid: true}
+		}
+		_, err := stmt.Exec(site, answer.Answer_id, answer.Question_id, answer.Owner.User_id, html.UnescapeString(answer.Owner.Display_name), answer.Creation_date, answer.Score, answer.Is_accepted, accepted)
+		if err != nil {
+			applog.Errorf(ctx, "Error adding answer %v: %v", answer.Answer_id, err.Error())
+		}
+	}
+	return nil
+}
+
+// Collects the answers to every question in the db from StackExchange and stores them
+func RefreshAnswers(db *sql.DB, ctx context.Context) error {
+	sites, err := QuestionSites(db)
+	if err != nil {
+		return err
+	}
+
+	for _, site := range sites {
+		ids, err := questionIDs(db, site, "")
+		if err != nil {
+			return err
+		}
+
+		// Store whatever answers were collected, even if the client stopped part way
+		answers, err := GetAnswers(site, ids)
+		if answers != nil {
+			if addErr := AddAnswers(db, ctx, site, answers.Items); addErr != nil {
+				return addErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the stored answers to a question on site, oldest first
+func ReadAnswers(db *sql.DB, site string, questionID int) ([]stackongo.Answer, error) {
+	key := QuestionKey{site, questionID}
+	answers, err := ReadAnswersOf(db, []QuestionKey{key})
+	if answers[key] == nil {
+		return []stackongo.Answer{}, err
+	}
+	return answers[key], err
+}
+
+// Returns the stored answers to each of the questions with keys, oldest first, in one query
+func ReadAnswersOf(db *sql.DB, keys []QuestionKey) (map[QuestionKey][]stackongo.Answer, error) {
+	answers := make(map[QuestionKey][]stackongo.Answer)
+	if len(keys) == 0 {
+		return answers, nil
+	}
+	inKeys, args := keysIn("answers", keys)
+	rows, err := db.Query("SELECT site, question_id, answer_id, user_id, user_name, creation_date, score, is_accepted FROM answers "+
+		"WHERE "+inKeys+" ORDER BY creation_date", args...)
+	if err != nil {
+		return answers, fmt.Errorf("Answer query failed: %v", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key    QuestionKey
+			answer stackongo.Answer
+			name   sql.NullString
+		)
+		if err := rows.Scan(&key.Site, &key.ID, &answer.Answer_id, &answer.Owner.User_id, &name, &answer.Creation_date, &answer.Score, &answer.Is_accepted); err != nil {
+			return answers, fmt.Errorf("Answer scan failed: %v", err.Error())
+		}
+		answer.Question_id = key.ID
+		answer.Owner.Display_name = name.String
+		answers[key] = append(answers[key], answer)
+	}
+	return answers, rows.Err()
+}
@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"dataCollect"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Answers StackExchange API requests in tests, creating any filter asked for
+type fakeAPI struct {
+	lock     sync.Mutex
+	Requests []*url.URL // Every request other than filter creation, in the order sent
+	// Returns the status and body of the response to a request for path, eg. "search/advanced"
+	Answer func(path string, query url.Values) (int, string)
+}
+
+func (a *fakeAPI) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := strings.TrimPrefix(req.URL.Path, "/2.2/")
+	status, body := http.StatusOK, `{"items":[{"filter":"!test"}]}`
+	if path != "filters/create" {
+		a.lock.Lock()
+		a.Requests = append(a.Requests, req.URL)
+		answer := a.Answer
+		a.lock.Unlock()
+		status, body = answer(path, req.URL.Query())
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Sends the backend's API requests to a fake answering with answer, through a new client and filter cache
+// Returns the fake and a function that puts the previous client back
+func useAPI(answer func(path string, query url.Values) (int, string)) (*fakeAPI, func()) {
+	api := &fakeAPI{Answer: answer}
+	previousClient, previousFilters, previousTransport := client, appInfo.Filters, transport
+	client = dataCollect.NewClient()
+	client.Interval = 0
+	appInfo.Filters = dataCollect.NewFilterCache()
+	UseTransport(api)
+	return api, func() {
+		client, appInfo.Filters = previousClient, previousFilters
+		UseTransport(previousTransport)
+	}
+}
@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Who a question is assigned to, and when it is due
+// Assignments are kept apart from the question's state and owner, so a question can be
+// assigned before anyone starts on it and keeps its assignment as its state changes.
+type Assignment struct {
+	Assignee     int    // Id of the team member the question is assigned to
+	AssigneeName string // Display name of the assignee
+	AssignedBy   int    // Id of the team member who assigned it
+	Assigned     int64  // When the question was assigned
+	Due          int64  // When the question is due, or 0 if it has no due date
+}
+
+// Returns true if the assignment has a due date that has passed
+func (a Assignment) Overdue() bool {
+	return a.Due != 0 && time.Now().Unix() > a.Due
+}
+
+// Returned when a question is assigned to someone who is not on the team, or has never logged in to the tracker
+var ErrUnknownAssignee = errors.New("Assignee is not a member of the team")
+
+// Returned when a question that is not tracked is assigned
+var ErrUntrackedQuestion = errors.New("Question is not tracked")
+
+// Assigns a question on site to a team member, replacing any earlier assignment
+// A zero due time leaves the question without a due date.
+// Returns ErrUnknownAssignee without assigning it unless the assignee is a team member with a user in db,
+// and ErrUntrackedQuestion unless the question is in db.
+func AssignQuestion(db *sql.DB, ctx context.Context, site string, id int, assignee int, assignedBy int, due time.Time) error {
+	if !IsTeamMember(assignee) {
+		return ErrUnknownAssignee
+	}
+	var known, tracked bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE id=?), EXISTS(SELECT 1 FROM questions WHERE site=? AND question_id=?)",
+		assignee, site, id).Scan(&known, &tracked); err != nil {
+		return fmt.Errorf("Assignment query failed: %v", err.Error())
+	}
+	if !known {
+		return ErrUnknownAssignee
+	}
+	if !tracked {
+		return ErrUntrackedQuestion
+	}
+
+	var dueDate sql.NullInt64
+	if !due.IsZero() {
+		dueDate = sql.NullInt64{Int64: due.Unix(), Valid: true}
+	}
+	_, err := db.Exec("INSERT INTO assignment(site, question_id, assignee, assigned_by, assigned_at, due_date) VALUES (?, ?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE assignee=VALUES(assignee), assigned_by=VALUES(assigned_by), assigned_at=VALUES(assigned_at), due_date=VALUES(due_date)",
+		site, id, assignee, assignedBy, time.Now().Unix(), dueDate)
+	if err != nil {
+		return fmt.Errorf("Assignment failed: %v", err.Error())
+	}
+	applog.Infof(ctx, "Question %v on %v assigned to user %v by user %v", id, site, assignee, assignedBy)
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
+
+// Removes the assignment of a question on site
+func UnassignQuestion(db *sql.DB, ctx context.Context, site string, id int) error {
+	if _, err := db.Exec("DELETE FROM assignment WHERE site=? AND question_id=?", site, id); err != nil {
+		return fmt.Errorf("Unassignment failed: %v", err.Error())
+	}
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Sets the team and admins for a test, returning a function that clears them
+func useTeam(team string, admins string) func() {
+	os.Setenv("STACKTRACKER_TEAM", team)
+	os.Setenv("STACKTRACKER_ADMINS", admins)
+	return func() {
+		os.Unsetenv("STACKTRACKER_TEAM")
+		os.Unsetenv("STACKTRACKER_ADMINS")
+	}
+}
+
+// Answers the assignment check as if the assignee has logged in if known, and the question is tracked if tracked
+func assignmentAnswer(known bool, tracked bool) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT EXISTS") {
+			return []string{"known", "tracked"}, [][]driver.Value{{known, tracked}}, nil
+		}
+		return nil, nil, nil
+	}
+}
+
+func TestTeamIDs(t *testing.T) {
+	defer useTeam("1, 2,x", "2,3")()
+	if got, want := TeamIDs(), []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("team %v, want %v", got, want)
+	}
+	if !IsTeamMember(3) || IsTeamMember(4) || IsTeamMember(0) {
+		t.Error("admins are members, and no one else is")
+	}
+	if !IsAdmin(3) || IsAdmin(1) {
+		t.Error("only listed admins are admins")
+	}
+	if condition, args := TeamIn("user.id"); condition != "user.id IN (?, ?, ?)" || len(args) != 3 {
+		t.Errorf("team condition %v %v", condition, args)
+	}
+}
+
+func TestTeamInEmptyTeam(t *testing.T) {
+	defer useTeam("", "")()
+	if condition, args := TeamIn("user.id"); condition != "FALSE" || args != nil {
+		t.Errorf("empty team condition %v %v, want one matching nothing", condition, args)
+	}
+}
+
+func TestAssignQuestion(t *testing.T) {
+	defer useTeam("42", "")()
+	tests := []struct {
+		name     string
+		assignee int
+		known    bool
+		tracked  bool
+		err      error
+	}{
+		{"team member", 42, true, true, nil},
+		{"not a member", 7, true, true, ErrUnknownAssignee},
+		{"member who never logged in", 42, false, true, ErrUnknownAssignee},
+		{"untracked question", 42, true, false, ErrUntrackedQuestion},
+	}
+	for _, test := range tests {
+		db, fake := openFakeDB(t, assignmentAnswer(test.known, test.tracked))
+		err := AssignQuestion(db, context.Background(), "stackoverflow", 1, test.assignee, 42, time.Time{})
+		if err != test.err {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.err)
+		}
+		if inserted := statementArgs(fake, "INSERT INTO assignment") != nil; inserted != (test.err == nil) {
+			t.Errorf("%v: assignment inserted %v, want %v", test.name, inserted, test.err == nil)
+		}
+		db.Close()
+	}
+}
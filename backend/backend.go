@@ -2,11 +2,13 @@ package backend
 
 import (
 	"dataCollect"
+	"database/sql"
 	"errors"
-	"fmt"
 	"net/http"
+	"os"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
@@ -17,26 +19,23 @@ import (
 )
 
 var (
-	transport http.RoundTripper                                // Interface to handle HTTP transactions
-	keyWords  = "places api"                                   // Main search parameters
-	tags      = []string{"google-places-api", "google-places"} // Tags to search for
-	appInfo   = dataCollect.AppDetails{                        // Information on StackExchange app
+	transport http.RoundTripper         // Interface to handle HTTP transactions
+	appInfo   = dataCollect.AppDetails{ // Information on StackExchange app
 		Client_id:     "6029",
 		Redirect_uri:  "http://mtest.stacktracker-1184.appspot.com/home",
 		Client_secret: "ymefu0zw2TIULhSTM03qyg((",
 		Key:           "nHI22oWrBEsUN8kHe4ARsQ((",
 
-		Filters: "!*7Pmg80Pr9s5.fICa8ob-dRh8NP6",
-		// Filters include:
-		//	- Wrapper: backoff, error_id, error_message, error_name,
-		//             has_more, items, page, quota_remaining
-		//	- Question: body, creation_date, link, question_id, tags, title
+		// Filters are created for the fields each fetcher requests, and stored in the db once it is opened
+		Filters: dataCollect.NewFilterCache(),
 
 		Options: map[string]string{
 			"scope": "write_access, no_expiry",
 		},
 	}
-	session = new(stackongo.Session) // Session to access StackExchange API
+	sessions    = make(map[string]*stackongo.Session) // Sessions to access StackExchange API, by site
+	sessionLock sync.Mutex
+	client      = dataCollect.NewClient() // Paces requests to the StackExchange API across app requests
 )
 
 // Functions and type for sorting an array of Questions
@@ -47,89 +46,86 @@ func (a byCreationDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byCreationDate) Less(i, j int) bool { return a[i].Creation_date > a[j].Creation_date }
 
 // Setting the transport to allow stackongo to call StackExchange API
+// If STACKEXCHANGE_FIXTURES is set, responses are recorded to or replayed from the fixture
+// files in its directory, depending on STACKEXCHANGE_MODE being "record" or "replay"
+// The transport is shared, so API calls made while serving one request may use the context of another.
 func SetTransport(c context.Context) {
-	transport = &urlfetch.Transport{Context: c}
-	stackongo.SetTransport(transport)
+	var t http.RoundTripper = &urlfetch.Transport{Context: c}
+	if dir := os.Getenv("STACKEXCHANGE_FIXTURES"); dir != "" {
+		switch os.Getenv("STACKEXCHANGE_MODE") {
+		case "record":
+			t = &dataCollect.RecordingTransport{Transport: t, Dir: dir}
+		case "replay":
+			t = &dataCollect.ReplayTransport{Dir: dir}
+		}
+	}
+	UseTransport(t)
 }
 
-// Create a new stackongo session
-func NewSession() {
-	session = stackongo.NewSession("stackoverflow")
+// Sends all StackExchange API requests through t
+func UseTransport(t http.RoundTripper) {
+	transport = t
+	stackongo.SetTransport(transport)
+	client.SetTransport(transport)
 }
 
-// Returns StackOverflow questions asked between fromDate and toDate
-func GetNewQns(fromDate time.Time, toDate time.Time) (*stackongo.Questions, error) {
-	// Adding parameters to request
-	params := make(stackongo.Params)
-	params.Pagesize(100)
-	params.Fromdate(fromDate)
-	params.Todate(toDate)
-	params.Sort("creation")
-	params.Add("accepted", false)
-	params.Add("closed", false)
-
-	// Add questions tagged with "google-places-api"
-	params.Add("tagged", tags[0])
-	questions, err := dataCollect.Collect(appInfo, params, transport)
-	if err != nil {
-		return nil, err
-	}
-
-	// Return if no quota remaining for the app
-	if questions.Quota_remaining <= 0 {
-		return questions, fmt.Errorf("No StackExchange requests remaining")
-	}
-
-	// Add questions tagged with "google-places"
-	params.Add("tagged", tags[1])
-	params.Add("nottagged", tags[0])
-	tagQuestions, err := dataCollect.Collect(appInfo, params, transport)
-	if err != nil {
-		return questions, err
-	}
-	tagQuestions.Items = append(questions.Items, tagQuestions.Items...)
-	questions = tagQuestions
-	sort.Sort(byCreationDate(questions.Items))
-
-	// Return if no quota remaining for the app
-	if questions.Quota_remaining <= 0 {
-		return questions, fmt.Errorf("No StackExchange requests remaining")
+// Create new stackongo sessions for each of sites, and for the site users log in through
+// Sessions for other sites are created when first needed
+func NewSession(sites ...string) {
+	siteSession(LoginSite)
+	for _, site := range sites {
+		siteSession(site)
 	}
+}
 
-	// Add questions with "Places API" in the body
-	params.Del("tagged")
-	params.Add("body", keyWords)
-	params.AddVectorized("nottagged", tags)
-	bodyQuestions, err := dataCollect.Collect(appInfo, params, transport)
-	if err != nil {
-		return questions, err
+// Returns questions on site asked up to toDate that match any of the watches searching that site
+// Also returns the ids of the watches that matched each question, keyed by question id
+// Each search carries on from its stored watermark, and searches never synced before start at fromDate.
+// Searches stopped by an earlier sync are resumed from their checkpoints.
+// If the client stops, the questions collected so far are returned along with the error.
+func GetNewQns(db *sql.DB, ctx context.Context, site string, watches []Watch, fromDate time.Time, toDate time.Time) (*stackongo.Questions, map[int][]int, error) {
+	questions := new(stackongo.Questions)
+	matches := make(map[int][]int)
+
+	for _, watch := range watches {
+		if watch.Site != site {
+			continue
+		}
+		for _, params := range watch.queries() {
+			newQns, err := collectQuery(db, ctx, watch, params, fromDate, toDate)
+			for _, item := range newQns.Items {
+				if _, ok := matches[item.Question_id]; !ok {
+					questions.Items = append(questions.Items, item)
+				}
+				if !containsInt(matches[item.Question_id], watch.ID) {
+					matches[item.Question_id] = append(matches[item.Question_id], watch.ID)
+				}
+			}
+			questions.Quota_remaining = newQns.Quota_remaining
+			if err != nil {
+				sort.Sort(byCreationDate(questions.Items))
+				return questions, matches, err
+			}
+		}
 	}
-	bodyQuestions.Items = append(questions.Items, bodyQuestions.Items...)
-	questions = bodyQuestions
 	sort.Sort(byCreationDate(questions.Items))
 
-	// Return if no quota remaining for the app
-	if questions.Quota_remaining <= 0 {
-		return questions, fmt.Errorf("No StackExchange requests remaining")
-	}
+	return questions, matches, nil
+}
 
-	// Add questions with "Places API" in the body
-	params.Del("body")
-	params.Add("title", keyWords)
-	titleQuestions, err := dataCollect.Collect(appInfo, params, transport)
-	if err != nil {
-		return questions, err
+// Returns true if toFind is an element of slice
+func containsInt(slice []int, toFind int) bool {
+	for _, i := range slice {
+		if i == toFind {
+			return true
+		}
 	}
-	titleQuestions.Items = append(questions.Items, titleQuestions.Items...)
-	questions = titleQuestions
-	sort.Sort(byCreationDate(questions.Items))
-
-	return questions, nil
+	return false
 }
 
 // Return questions based on search parameters
 func NewSearch(r *http.Request, params stackongo.Params) (*stackongo.Questions, error) {
-	return dataCollect.Collect(appInfo, params, transport)
+	return dataCollect.Collect(client, appInfo, params)
 }
 
 // Return URL to redirect user for authentication
@@ -144,13 +140,13 @@ func ObtainAccessToken(code, uri string) (map[string]string, error) {
 
 // Return User associated with access_token
 func AuthenticatedUser(params map[string]string, access_token string) (stackongo.User, error) {
-	return session.AuthenticatedUser(params, map[string]string{"key": appInfo.Key, "access_token": access_token})
+	return siteSession(LoginSite).AuthenticatedUser(params, map[string]string{"key": appInfo.Key, "access_token": access_token})
 }
 
-// Return User associated with user_id
-func GetUser(user_id int, params map[string]string) (stackongo.User, error) {
-	params["key"] = appInfo.Key
-	users, err := session.GetUsers([]int{user_id}, params)
+// Return User associated with user_id on site
+func GetUser(site string, user_id int, params stackongo.Params) (stackongo.User, error) {
+	params.Add("site", site)
+	users, err := dataCollect.GetUsersByIDs(client, []int{user_id}, appInfo, params)
 	if err != nil {
 		return stackongo.User{}, err
 	}
@@ -163,13 +159,13 @@ func GetUser(user_id int, params map[string]string) (stackongo.User, error) {
 // Function to make a fresh request to the Stack Exchange API to return questions relating to set of ID's
 // Initiates parameters required to make the request.
 // The returning data is then sent back to the webui handler to be parsed into the page
-func GetQuestions(ctx context.Context, ids []int) (*stackongo.Questions, error) {
+func GetQuestions(ctx context.Context, site string, ids []int) (*stackongo.Questions, error) {
 	params := make(stackongo.Params)
 	params.Pagesize(100)
 	params.Sort("creation")
-	params.AddVectorized("tagged", tags)
+	params.Add("site", site)
 
-	questions, err := dataCollect.GetQuestionsByIDs(session, ids, appInfo, params)
+	questions, _, err := dataCollect.GetQuestionsByIDs(client, ids, appInfo, params)
 	if err != nil {
 		return nil, errors.New("Error collection new question by id\t" + err.Error())
 	}
@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestConflictErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ConflictError
+		want string
+	}{
+		{"owned", ConflictError{Site: "stackoverflow", QuestionID: 7, State: "pending", UserID: 3, UserName: "Ana"},
+			"Question 7 on stackoverflow has already been marked as pending by Ana"},
+		{"not owned", ConflictError{Site: "stackoverflow", QuestionID: 7, State: "unanswered"},
+			"Question 7 on stackoverflow has already been marked as unanswered"},
+		{"no longer tracked", ConflictError{Site: "stackoverflow", QuestionID: 7},
+			"Question 7 on stackoverflow is no longer tracked"},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestUpdateQnsConflict(t *testing.T) {
+	defer useWorkflow(t, testWorkflow())()
+	tests := []struct {
+		name  string
+		rows  [][]driver.Value // The question's stored state, owner and owner's name
+		state string
+		user  int
+	}{
+		{"moved on by someone else", [][]driver.Value{{"answered", int64(3), "Ana"}}, "answered", 3},
+		{"no longer tracked", nil, "", 0},
+	}
+	for _, test := range tests {
+		db, fake := openFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+			if strings.HasPrefix(query, "SELECT questions.state") {
+				return []string{"state", "user", "name"}, test.rows, nil
+			}
+			return nil, nil, nil
+		})
+		// The question has left the state the user saw, so the update changes nothing
+		fake.Affected = func(query string) int64 { return 0 }
+		err := UpdateQns(db, context.Background(), "stackoverflow", 7, "unanswered", "pending", 42, 1000, "")
+		conflict, ok := err.(*ConflictError)
+		if !ok || conflict.State != test.state || conflict.UserID != test.user {
+			t.Errorf("%v: got %v, want a conflict with the question in %q owned by %v", test.name, err, test.state, test.user)
+		}
+		if statementArgs(fake, "INSERT INTO question_history") != nil {
+			t.Errorf("%v: recorded a move that did not happen", test.name)
+		}
+		db.Close()
+	}
+}
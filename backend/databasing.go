@@ -1,14 +1,11 @@
 package backend
 
 import (
-	"dataCollect"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"html"
 	"log"
 	//"os"
-	"strconv"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -51,16 +48,19 @@ func SqlInit(dbString string) *sql.DB {
 		log.Println("Database initialized successfully!")
 	}
 
+	// Created filters are kept in the db, so every instance reuses them
+	appInfo.Filters.SetStore(filterStore{db})
+
 	//Return the db pointer for use elsewhere, as it has now been successfully created
 	return db
 }
 
-// This function checks if an existing question is already present in the database, based on ID
+// This function checks if an existing question is already present in the database, based on site and ID
 // If so, doing a call to the StackExchange API is useless, and a waste of our daily quota
 // SELECT EXIST returns a single row with a 1 or 0 depending on whether or not a record exists
-func CheckForExistingQuestion(db *sql.DB, id int) (int, error) {
+func CheckForExistingQuestion(db *sql.DB, site string, id int) (int, error) {
 	res := 0
-	rows, err := db.Query("SELECT EXISTS(SELECT * FROM questions where question_id=?)", id)
+	rows, err := db.Query("SELECT EXISTS(SELECT * FROM questions where site=? AND question_id=?)", site, id)
 	if err != nil {
 		return res, err
 	}
@@ -79,28 +79,35 @@ func CheckForExistingQuestion(db *sql.DB, id int) (int, error) {
 	return res, nil
 }
 
-// Given a question ID, it pulls that from the database
+// Given a site and question ID, it pulls that question from the database
 // Marshalls the result as JSON data to be returned in a reply
 // Checks if a question is unanswered, if not it pulls the display name for that user
-func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
+// The team's notes on the question are only included if withNotes is set, for team members
+func PullQnByID(db *sql.DB, ctx context.Context, site string, id int, withNotes bool) []byte {
 
 	type newQ struct {
 		Message string
 
+		Site          string
 		Question_id   int
 		Creation_date int64
 		Link          string
 		Body          string
 		Title         string
 		Tags          []string
+		Answers       []stackongo.Answer
+		History       []Transition
+		Notes         []Note
 
 		State           string
 		UserID          string
 		UserDisplayName string
 		Time            string
+		StateReason     string
+		UpstreamChanged string
 	}
 
-	rows, err := db.Query("SELECT * FROM questions where question_id=?", id)
+	rows, err := db.Query("SELECT site, question_id, question_title, question_url, state, user, body, creation_date, time_updated, state_reason, upstream_changed FROM questions where site=? AND question_id=?", site, id)
 	if err != nil {
 		applog.Warningf(ctx, "Question query failed: %v", err.Error())
 		return []byte{}
@@ -111,8 +118,10 @@ func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
 	n.Message = "Question already exists in database. See below."
 	for rows.Next() {
 		var sqlTime sql.NullInt64
+		var reason sql.NullString
+		var upstreamTime sql.NullInt64
 		var t int64
-		err := rows.Scan(&n.Question_id, &n.Title, &n.Link, &n.State, &n.UserID, &n.Body, &n.Creation_date, &sqlTime)
+		err := rows.Scan(&n.Site, &n.Question_id, &n.Title, &n.Link, &n.State, &n.UserID, &n.Body, &n.Creation_date, &sqlTime, &reason, &upstreamTime)
 		if err != nil {
 			applog.Errorf(ctx, "Question scan failed: %v", err.Error())
 			continue
@@ -123,7 +132,11 @@ func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
 		} else {
 			n.Time = ""
 		}
-		if n.State != "unanswered" {
+		n.StateReason = reason.String
+		if upstreamTime.Valid {
+			n.UpstreamChanged = time.Unix(upstreamTime.Int64, 0).Format("Jan 2 at 15:04")
+		}
+		if workflow.Owned(n.State) {
 			userRows, err := db.Query("SELECT name FROM user WHERE id=?", n.UserID)
 			if err != nil {
 				log.Println(err)
@@ -144,7 +157,7 @@ func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
 			n.UserDisplayName = ""
 		}
 
-		tagRows, err := db.Query("SELECT tag from question_tag where question_id = ?", id)
+		tagRows, err := db.Query("SELECT tag from question_tag where site = ? AND question_id = ?", site, id)
 		if err != nil {
 			applog.Errorf(ctx, "Tag query failed: %v", err.Error())
 			continue
@@ -159,6 +172,21 @@ func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
 			}
 			n.Tags = append(n.Tags, currentTag)
 		}
+
+		n.Answers, err = ReadAnswers(db, site, id)
+		if err != nil {
+			applog.Errorf(ctx, "%v", err.Error())
+		}
+		n.History, err = ReadHistory(db, site, id)
+		if err != nil {
+			applog.Errorf(ctx, "%v", err.Error())
+		}
+		if withNotes {
+			n.Notes, err = ReadNotes(db, site, id)
+			if err != nil {
+				applog.Errorf(ctx, "%v", err.Error())
+			}
+		}
 	}
 	err = rows.Err()
 	if err != nil {
@@ -172,54 +200,66 @@ func PullQnByID(db *sql.DB, ctx context.Context, id int) []byte {
 	return b
 }
 
-// Adds a single question into the database, accepting a question, the questions state
-// and the users id. The state will determine whether or not it needs to be timestamped.
-// Returns an error on fail
-func AddSingleQuestion(db *sql.DB, item stackongo.Question, state string, user int) error {
-	if state == "pending" || state == "answered" || state == "updating" {
+// Adds a single question into the database, accepting the site it was asked on, a question, the questions state
+// and the users id. Questions added in an owned state are timestamped.
+// Returns an error on fail, or if the state is not in the workflow
+func AddSingleQuestion(db *sql.DB, site string, item stackongo.Question, state string, user int) error {
+	_, err := insertQuestion(db, site, item, state, user)
+	return err
+}
+
+// Adds a question as AddSingleQuestion does
+// Returns whether the question was new, rather than already in the database
+func insertQuestion(db *sql.DB, site string, item stackongo.Question, state string, user int) (bool, error) {
+	if !workflow.Valid(state) {
+		return false, fmt.Errorf("Unknown state %q", state)
+	}
+	var result sql.Result
+	if workflow.Owned(state) {
 		//INSERT IGNORE ensures that the same question won't be added again
-		stmt, err := db.Prepare("INSERT IGNORE INTO questions(question_id, question_title, question_URL, body, creation_date, state, user, time_updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+		stmt, err := db.Prepare("INSERT IGNORE INTO questions(site, question_id, question_title, question_URL, body, creation_date, state, user, time_updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
 		if err != nil {
-			return err
+			return false, err
 		}
-		_, err = stmt.Exec(item.Question_id, html.UnescapeString(item.Title), item.Link, html.UnescapeString(StripTags(item.Body)), item.Creation_date, state, user, time.Now().Unix())
+		result, err = stmt.Exec(site, item.Question_id, storedTitle(item.Title), item.Link, storedBody(item.Body), item.Creation_date, state, user, time.Now().Unix())
 		if err != nil {
 			log.Println("Exec insertion for question failed!:\t", err)
-			return err
+			return false, err
 		}
 	} else {
 		//INSERT IGNORE ensures that the same question won't be added again
-		stmt, err := db.Prepare("INSERT IGNORE INTO questions(question_id, question_title, question_URL, body, creation_date, state, user) VALUES (?, ?, ?, ?, ?, ?, ?)")
+		stmt, err := db.Prepare("INSERT IGNORE INTO questions(site, question_id, question_title, question_URL, body, creation_date, state, user) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
 		if err != nil {
-			return err
+			return false, err
 		}
-		_, err = stmt.Exec(item.Question_id, html.UnescapeString(item.Title), item.Link, html.UnescapeString(StripTags(item.Body)), item.Creation_date, state, user)
+		result, err = stmt.Exec(site, item.Question_id, storedTitle(item.Title), item.Link, storedBody(item.Body), item.Creation_date, state, user)
 		if err != nil {
 			log.Println("Exec insertion for question failed!:\t", err)
-			return err
+			return false, err
 		}
 	}
 
 	for _, tag := range item.Tags {
-		stmt, err := db.Prepare("INSERT IGNORE INTO question_tag(question_id, tag) VALUES(?, ?)")
+		stmt, err := db.Prepare("INSERT IGNORE INTO question_tag(site, question_id, tag) VALUES(?, ?, ?)")
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		_, err = stmt.Exec(item.Question_id, tag)
+		_, err = stmt.Exec(site, item.Question_id, tag)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
-	return nil
+	inserted, err := result.RowsAffected()
+	return inserted > 0, err
 }
 
-// Adds a set of questions into the database, by calling the AddSingleQuestions function
-// Default state for new questions is unanswered, with a user ID of 0.
-func AddQuestions(db *sql.DB, ctx context.Context, newQns *stackongo.Questions) error {
+// Adds a set of questions from site into the database, by calling the AddSingleQuestions function
+// New questions are added in the workflow's initial state, with a user ID of 0.
+func AddQuestions(db *sql.DB, ctx context.Context, site string, newQns *stackongo.Questions) error {
 
 	for _, item := range newQns.Items {
-		err := AddSingleQuestion(db, item, "unanswered", 0)
+		err := AddSingleQuestion(db, site, item, workflow.Initial, 0)
 		if err != nil {
 			applog.Errorf(ctx, "Error adding question %v: %v", item.Question_id, err.Error())
 		}
@@ -228,79 +268,6 @@ func AddQuestions(db *sql.DB, ctx context.Context, newQns *stackongo.Questions)
 	return nil
 }
 
-// Remove any questions in db that does not exist in StackOverflow
-func RemoveDeletedQuestions(db *sql.DB, ctx context.Context) error {
-	defer UpdateTableTimes(db, ctx, "questions")
-
-	// Get questions from db
-	ids := []int{}
-	rows, err := db.Query("SELECT question_id FROM questions")
-	if err != nil {
-		return err
-	}
-	var id int
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&id)
-		if err != nil {
-			return err
-		}
-		ids = append(ids, id)
-	}
-
-	// Get the questions from StackExchange
-	params := make(stackongo.Params)
-	params.Pagesize(100)
-	params.Sort("creation")
-
-	questions, err := dataCollect.GetQuestionsByIDs(session, ids, appInfo, params)
-	if err != nil {
-		return err
-	}
-
-	// If the number of questions equal, no questions have been deleted
-	if len(questions.Items) == len(ids) {
-		return nil
-	}
-
-	// Find the questions not in SE
-	// Add to deletedQns
-	deletedQns := make([]int, 0, len(ids)-len(questions.Items))
-	for _, id := range ids {
-		deleted := true
-		for _, question := range questions.Items {
-			if question.Question_id == id {
-				deleted = false
-				break
-			}
-		}
-		if deleted {
-			deletedQns = append(deletedQns, id)
-		}
-	}
-
-	// Remove deletedQns from db
-	query := "DELETE FROM questions WHERE "
-	for i, id := range deletedQns {
-		query += "question_id=" + strconv.Itoa(id)
-		if i < len(deletedQns)-1 {
-			query += " OR "
-		}
-	}
-	_, err = db.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	// Remove tags associated with deleted questions
-	_, err = db.Exec("DELETE FROM question_tag WHERE question_id NOT IN (SELECT questions.question_id FROM questions)")
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // A crude way to find out if the working cache needs to be refreshed from the database.
 // Stores the current Unix time in update_times table on Cloud SQL
 func UpdateTableTimes(db *sql.DB, ctx context.Context, tableName string) {
@@ -319,8 +286,46 @@ func UpdateTableTimes(db *sql.DB, ctx context.Context, tableName string) {
 	}
 }
 
+// Returned when a question has already left the state it was being moved from, usually because
+// another team member moved it first. Holds the state and owner the question has now.
+type ConflictError struct {
+	Site       string
+	QuestionID int
+	State      string // State the question is in now, or "" if it is no longer in the db
+	UserID     int    // Id of the question's owner now, or 0 if it has none
+	UserName   string // Display name of the owner
+}
+
+func (e *ConflictError) Error() string {
+	if e.State == "" {
+		return fmt.Sprintf("Question %v on %v is no longer tracked", e.QuestionID, e.Site)
+	}
+	if e.UserName != "" {
+		return fmt.Sprintf("Question %v on %v has already been marked as %v by %v", e.QuestionID, e.Site, e.State, e.UserName)
+	}
+	return fmt.Sprintf("Question %v on %v has already been marked as %v", e.QuestionID, e.Site, e.State)
+}
+
+// Returns a ConflictError with the current state and owner of a question on site
+func readConflict(db *sql.DB, site string, id int) error {
+	var (
+		state  string
+		userID sql.NullInt64
+		name   sql.NullString
+	)
+	err := db.QueryRow("SELECT questions.state, questions.user, user.name FROM questions LEFT JOIN user ON questions.user=user.id "+
+		"WHERE questions.site=? AND questions.question_id=?", site, id).Scan(&state, &userID, &name)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("State query failed: %v", err.Error())
+	}
+	return &ConflictError{Site: site, QuestionID: id, State: state, UserID: int(userID.Int64), UserName: name.String}
+}
+
 // Function to update the questions in qns in the database
-func UpdateQns(db *sql.DB, ctx context.Context, qn int, originalState string, state string, userId int, lastUpdate int64) error {
+// The change is recorded in the question's history along with the comment, which may be empty
+// Returns ErrTransitionNotAllowed unless the workflow allows moving from originalState to state,
+// and a *ConflictError if the question is no longer in originalState
+func UpdateQns(db *sql.DB, ctx context.Context, site string, qn int, originalState string, state string, userId int, lastUpdate int64, comment string) error {
 	applog.Infof(ctx, "Updating database")
 
 	if qn == 0 {
@@ -333,19 +338,49 @@ func UpdateQns(db *sql.DB, ctx context.Context, qn int, originalState string, st
 		applog.Infof(ctx, "Database updated")
 	}(db, ctx)
 
-	//Update the database, setting the state and the new user/owner of that question.
-	stmts, err := db.Prepare("UPDATE questions SET state=?,user=?,time_updated=? WHERE question_id=? AND state=?")
-	if err != nil {
-		return fmt.Errorf("Update prepare failed: %v", err.Error())
+	if !workflow.Allowed(originalState, state) {
+		return ErrTransitionNotAllowed
+	}
+	// Only owned states keep who moved the question there as its owner, the history always has who moved it
+	owner := userId
+	if !workflow.Owned(state) {
+		owner = 0
 	}
 
-	if state == "unanswered" {
-		userId = 0
+	// The move and its history are saved together, or not at all
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Update transaction failed: %v", err.Error())
 	}
 
-	_, err = stmts.Exec(state, userId, lastUpdate, qn, originalState)
+	//Update the database, setting the state and the new user/owner of that question.
+	//Any reason left by an automatic change no longer applies.
+	result, err := tx.Exec("UPDATE questions SET state=?,user=?,time_updated=?,state_reason=NULL WHERE site=? AND question_id=? AND state=?",
+		state, owner, lastUpdate, site, qn, originalState)
 	if err != nil {
+		tx.Rollback()
 		return fmt.Errorf("Update execution failed: %v", err.Error())
 	}
+	// Nothing was moved if the question had already left originalState
+	n, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Update execution failed: %v", err.Error())
+	}
+	if n == 0 {
+		tx.Rollback()
+		return readConflict(db, site, qn)
+	}
+
+	err = RecordTransition(tx, site, qn, Transition{
+		From: originalState, To: state, UserID: userId, Comment: comment, Time: time.Now().Unix(),
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Update commit failed: %v", err.Error())
+	}
 	return nil
 }
@@ -0,0 +1,136 @@
+package backend
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+)
+
+// A statement run against a fake db, and the arguments it was run with
+type fakeStatement struct {
+	Query string
+	Args  []driver.Value
+}
+
+// A db answering every query with a function, so code using database/sql can be tested without MySQL
+type fakeDB struct {
+	Statements []fakeStatement
+	// Returns the columns and rows for a query, nil rows for statements that return none
+	Answer func(query string, args []driver.Value) ([]string, [][]driver.Value, error)
+	// Returns the number of rows a statement changes, 1 if not set
+	Affected func(query string) int64
+}
+
+// The fake dbs open in tests, by name
+var fakeDBs = make(map[string]*fakeDB)
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+// Returns a db answering queries with answer, and the fake recording the statements run against it
+func openFakeDB(t *testing.T, answer func(query string, args []driver.Value) ([]string, [][]driver.Value, error)) (*sql.DB, *fakeDB) {
+	name := t.Name() + strconv.Itoa(len(fakeDBs))
+	fake := &fakeDB{Answer: answer}
+	fakeDBs[name] = fake
+	db, err := sql.Open("fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, fake
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fake, ok := fakeDBs[name]
+	if !ok {
+		return nil, errors.New("no fake db " + name)
+	}
+	return fakeConn{fake}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.db, query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) run(args []driver.Value) ([]string, [][]driver.Value, error) {
+	s.db.Statements = append(s.db.Statements, fakeStatement{s.query, args})
+	if s.db.Answer == nil {
+		return nil, nil, nil
+	}
+	return s.db.Answer(s.query, args)
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, _, err := s.run(args)
+	if s.db.Affected != nil {
+		return driver.RowsAffected(s.db.Affected(s.query)), err
+	}
+	return driver.RowsAffected(1), err
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	columns, rows, err := s.run(args)
+	return &fakeRows{columns: columns, rows: rows}, err
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// Returns true if a question returned by StackExchange was closed as a duplicate
+func isDuplicate(item stackongo.Question) bool {
+	return item.Closed_date != 0 && strings.EqualFold(item.Closed_reason, "duplicate")
+}
+
+// Links questions on site closed as duplicates to the question they duplicate, their canonical question
+// Canonical questions that are not tracked are added as unanswered, so the team answers them once for every duplicate.
+// tracked holds the ids of the questions from site already in db.
+// Returns the ids of the duplicates that were linked, and of those StackExchange gave no original question for.
+func linkDuplicates(db *sql.DB, site string, ids []int, tracked map[int]upstreamStatus) ([]int, []int, error) {
+	linked := []int{}
+	unlinked := []int{}
+	params := make(stackongo.Params)
+	params.Pagesize(100)
+	params.Add("site", site)
+
+	closed, fetchErr := dataCollect.GetClosedDetails(client, ids, appInfo, params)
+
+	imports := []int{}
+	for _, item := range closed.Items {
+		originals := item.Closed_details.Original_questions
+		if len(originals) == 0 {
+			unlinked = append(unlinked, item.Question_id)
+			continue
+		}
+		canonical := originals[0].Question_id
+		if _, err := db.Exec("UPDATE questions SET duplicate_of=? WHERE site=? AND question_id=?", canonical, site, item.Question_id); err != nil {
+			return linked, unlinked, fmt.Errorf("Duplicate update failed: %v", err.Error())
+		}
+		linked = append(linked, item.Question_id)
+
+		if _, ok := tracked[canonical]; !ok && !containsInt(imports, canonical) {
+			imports = append(imports, canonical)
+		}
+	}
+	if len(imports) == 0 {
+		return linked, unlinked, fetchErr
+	}
+
+	// Canonical questions are fetched with their bodies, to be stored like any other question
+	params = make(stackongo.Params)
+	params.Pagesize(100)
+	params.Add("site", site)
+	questions, _, err := dataCollect.GetQuestionsByIDs(client, imports, appInfo, params)
+	for _, question := range questions.Items {
+		if addErr := AddSingleQuestion(db, site, question, workflow.Initial, 0); addErr != nil {
+			return linked, unlinked, addErr
+		}
+	}
+	if err != nil {
+		return linked, unlinked, err
+	}
+	return linked, unlinked, fetchErr
+}
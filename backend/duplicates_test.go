@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestLinkDuplicates(t *testing.T) {
+	defer useWorkflow(t, testWorkflow())()
+	// 5 duplicates a question not tracked yet, 6 a tracked one, and StackExchange names no original for 7
+	api, restore := useAPI(func(path string, query url.Values) (int, string) {
+		switch path {
+		case "questions/5;6;7":
+			return 200, `{"items":[` +
+				`{"question_id":5,"closed_details":{"original_questions":[{"question_id":9}]}},` +
+				`{"question_id":6,"closed_details":{"original_questions":[{"question_id":1}]}},` +
+				`{"question_id":7,"closed_details":{}}]}`
+		case "questions/9":
+			return 200, `{"items":[{"question_id":9,"title":"How to geocode"}]}`
+		}
+		return 404, `{"error_id":404,"error_name":"no_method","error_message":"unexpected"}`
+	})
+	defer restore()
+	db, fake := openFakeDB(t, nil)
+	defer db.Close()
+
+	tracked := map[int]upstreamStatus{1: {StatusOpen, ""}, 5: {StatusOpen, ""}, 6: {StatusOpen, ""}, 7: {StatusOpen, ""}}
+	linked, unlinked, err := linkDuplicates(db, "stackoverflow", []int{5, 6, 7}, tracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(linked, []int{5, 6}) || !reflect.DeepEqual(unlinked, []int{7}) {
+		t.Errorf("linked %v and left %v unlinked, want 5 and 6 linked and 7 unlinked", linked, unlinked)
+	}
+
+	links := make(map[int64]int64)
+	for _, s := range fake.Statements {
+		if s.Query == "UPDATE questions SET duplicate_of=? WHERE site=? AND question_id=?" {
+			links[s.Args[2].(int64)] = s.Args[0].(int64)
+		}
+	}
+	if want := map[int64]int64{5: 9, 6: 1}; !reflect.DeepEqual(links, want) {
+		t.Errorf("linked duplicates %v, want %v", links, want)
+	}
+
+	// Only the canonical question that is not tracked is fetched and added, in the initial state
+	if len(api.Requests) != 2 {
+		t.Errorf("sent %v, want the closed details and the untracked canonical question", api.Requests)
+	}
+	added := statementArgs(fake, "INSERT IGNORE INTO questions")
+	if added == nil || added[1] != int64(9) || added[6] != "unanswered" {
+		t.Errorf("added %v, want question 9 unanswered", added)
+	}
+}
@@ -0,0 +1,35 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Stores the ids of the filters created for each fetcher's fields in the db
+type filterStore struct {
+	db *sql.DB
+}
+
+// Returns the filter stored under name and the fields it was created with, or "" if there is none
+func (s filterStore) LoadFilter(name string) (string, string, error) {
+	var filter, fields string
+	err := s.db.QueryRow("SELECT filter, fields FROM api_filter WHERE name=?", name).Scan(&filter, &fields)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", fmt.Errorf("Filter query failed: %v", err.Error())
+	}
+	return filter, fields, nil
+}
+
+// Stores the filter created for fields under name, replacing the filter for any older fields
+func (s filterStore) SaveFilter(name string, fields string, filter string) error {
+	_, err := s.db.Exec("INSERT INTO api_filter(name, fields, filter, time_created) VALUES (?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE fields=VALUES(fields), filter=VALUES(filter), time_created=VALUES(time_created)",
+		name, fields, filter, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Filter save failed: %v", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// A change of a question's state, made by a team member or by a transition rule
+type Transition struct {
+	From     string // State the question moved from
+	To       string // State the question moved to
+	UserID   int    // Id of the team member who moved it, or of the owner given by a rule
+	UserName string // Display name of the team member
+	RuleID   int    // Id of the transition rule that moved it, or 0 if it was moved by hand
+	Reason   string // Why a rule moved it
+	Comment  string // Comment left by the team member who moved it
+	Time     int64  // When it was moved
+}
+
+// Records a transition of a question on site in its history, in the transaction that moved the question
+func RecordTransition(tx *sql.Tx, site string, id int, t Transition) error {
+	var reason, comment sql.NullString
+	if t.Reason != "" {
+		reason = sql.NullString{String: t.Reason, Valid: true}
+	}
+	if t.Comment != "" {
+		comment = sql.NullString{String: t.Comment, Valid: true}
+	}
+	_, err := tx.Exec("INSERT INTO question_history(site, question_id, from_state, to_state, user_id, rule_id, reason, comment, time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		site, id, t.From, t.To, t.UserID, t.RuleID, reason, comment, t.Time)
+	if err != nil {
+		return fmt.Errorf("History insertion failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Returns the transitions of a question on site, oldest first
+func ReadHistory(db *sql.DB, site string, id int) ([]Transition, error) {
+	key := QuestionKey{site, id}
+	history, err := ReadHistoryOf(db, []QuestionKey{key})
+	return history[key], err
+}
+
+// Returns the transitions of each of the questions with keys, oldest first, in one query
+func ReadHistoryOf(db *sql.DB, keys []QuestionKey) (map[QuestionKey][]Transition, error) {
+	history := make(map[QuestionKey][]Transition)
+	if len(keys) == 0 {
+		return history, nil
+	}
+	inKeys, args := keysIn("question_history", keys)
+	rows, err := db.Query("SELECT question_history.site, question_history.question_id, question_history.from_state, question_history.to_state, "+
+		"question_history.user_id, user.name, question_history.rule_id, question_history.reason, question_history.comment, question_history.time "+
+		"FROM question_history LEFT JOIN user ON question_history.user_id=user.id "+
+		"WHERE "+inKeys+" ORDER BY question_history.time, question_history.id", args...)
+	if err != nil {
+		return history, fmt.Errorf("History query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key     QuestionKey
+			t       Transition
+			from    sql.NullString
+			userID  sql.NullInt64
+			name    sql.NullString
+			ruleID  sql.NullInt64
+			reason  sql.NullString
+			comment sql.NullString
+		)
+		if err := rows.Scan(&key.Site, &key.ID, &from, &t.To, &userID, &name, &ruleID, &reason, &comment, &t.Time); err != nil {
+			return history, fmt.Errorf("History scan failed: %v", err.Error())
+		}
+		t.From = from.String
+		t.UserID = int(userID.Int64)
+		t.UserName = name.String
+		t.RuleID = int(ruleID.Int64)
+		t.Reason = reason.String
+		t.Comment = comment.String
+		history[key] = append(history[key], t)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// Puts w in use for a test, returning a function that puts the previous workflow back
+func useWorkflow(t *testing.T, w *Workflow) func() {
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+	previous := workflow
+	workflow = w
+	return func() { workflow = previous }
+}
+
+// Returns the arguments of the first statement run against fake starting with prefix, or nil if none was
+func statementArgs(fake *fakeDB, prefix string) []driver.Value {
+	for _, s := range fake.Statements {
+		if strings.HasPrefix(s.Query, prefix) {
+			return s.Args
+		}
+	}
+	return nil
+}
+
+func TestUpdateQnsRecordsActor(t *testing.T) {
+	w := testWorkflow()
+	w.States[2].Transitions = append(w.States[2].Transitions, "unanswered")
+	defer useWorkflow(t, w)()
+
+	tests := []struct {
+		name     string
+		from, to string
+		owner    int64 // Owner stored on the question
+	}{
+		{"into an owned state", "unanswered", "pending", 42},
+		{"into a state without owners", "answered", "unanswered", 0},
+	}
+	for _, test := range tests {
+		db, fake := openFakeDB(t, nil)
+		if err := UpdateQns(db, context.Background(), "stackoverflow", 7, test.from, test.to, 42, 1000, "reopened"); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if update := statementArgs(fake, "UPDATE questions"); update == nil || update[1] != test.owner {
+			t.Errorf("%v: updated the question with %v, want owner %v", test.name, update, test.owner)
+		}
+		// Whoever moved the question is in its history, whether or not they own it
+		history := statementArgs(fake, "INSERT INTO question_history")
+		if history == nil || history[2] != test.from || history[3] != test.to || history[4] != int64(42) || history[7] != "reopened" {
+			t.Errorf("%v: recorded %v, want the move from %v to %v by user 42", test.name, history, test.from, test.to)
+		}
+		db.Close()
+	}
+}
+
+func TestUpdateQnsRefusesTransition(t *testing.T) {
+	defer useWorkflow(t, testWorkflow())()
+	db, fake := openFakeDB(t, nil)
+	defer db.Close()
+
+	if err := UpdateQns(db, context.Background(), "stackoverflow", 7, "pending", "unanswered", 42, 1000, ""); err != ErrTransitionNotAllowed {
+		t.Errorf("got %v, want %v", err, ErrTransitionNotAllowed)
+	}
+	if statementArgs(fake, "UPDATE questions") != nil || statementArgs(fake, "INSERT INTO question_history") != nil {
+		t.Error("moved a question the workflow does not allow")
+	}
+}
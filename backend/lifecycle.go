@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+)
+
+// Statuses of a question on the StackExchange site it was asked on
+const (
+	StatusOpen      = "open"
+	StatusClosed    = "closed"
+	StatusDuplicate = "duplicate" // Closed as a duplicate of another question
+	StatusDeleted   = "deleted"
+	StatusMigrated  = "migrated"
+	StatusLocked    = "locked"
+)
+
+// The status of a question on StackExchange, and why it has that status
+type upstreamStatus struct {
+	status string
+	reason string
+}
+
+// Returns the status of a question returned by StackExchange
+// Migrated questions are also closed, so migration is checked first
+func statusOf(item stackongo.Question) upstreamStatus {
+	if item.Migrated_to.Question_id != 0 {
+		return upstreamStatus{StatusMigrated, fmt.Sprintf("Migrated to another site as question %v", item.Migrated_to.Question_id)}
+	}
+	if isDuplicate(item) {
+		return upstreamStatus{StatusDuplicate, item.Closed_reason}
+	}
+	if item.Closed_date != 0 {
+		return upstreamStatus{StatusClosed, item.Closed_reason}
+	}
+	if item.Locked_date != 0 {
+		return upstreamStatus{StatusLocked, ""}
+	}
+	return upstreamStatus{StatusOpen, ""}
+}
+
+// Returns the stored status of every question on site, by question id
+func readStatuses(db *sql.DB, site string) (map[int]upstreamStatus, error) {
+	statuses := make(map[int]upstreamStatus)
+	rows, err := db.Query("SELECT question_id, upstream_status, upstream_reason FROM questions WHERE site=?", site)
+	if err != nil {
+		return statuses, fmt.Errorf("Status query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var (
+		id     int
+		status string
+		reason sql.NullString
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id, &status, &reason); err != nil {
+			return statuses, fmt.Errorf("Status scan failed: %v", err.Error())
+		}
+		statuses[id] = upstreamStatus{status, reason.String}
+	}
+	return statuses, rows.Err()
+}
+
+// Stores the status of a question on site
+// A question is hidden when it leaves the open status, and shown again if it reopens.
+// Duplicates are not hidden, as they are shown under the question they duplicate.
+// Questions restored by the team stay shown until their status changes again.
+func setUpstreamStatus(db *sql.DB, site string, id int, s upstreamStatus) error {
+	hide := s.status != StatusOpen && s.status != StatusDuplicate
+
+	// hidden is set first, so it is compared against the old status
+	// Questions that are no longer duplicates lose the link to their canonical question
+	_, err := db.Exec("UPDATE questions SET hidden=IF(upstream_status=?, hidden, ?), upstream_status=?, upstream_reason=?, "+
+		"duplicate_of=IF(?, duplicate_of, NULL) WHERE site=? AND question_id=?",
+		s.status, hide, s.status, s.reason, s.status == StatusDuplicate, site, id)
+	if err != nil {
+		return fmt.Errorf("Status update failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Updates the status of every question in db from the StackExchange site it was asked on, and snapshots its metrics
+// Questions that StackExchange no longer returns are marked deleted rather than removed,
+// so their state and history are kept if they come back.
+func RefreshQuestionStatuses(db *sql.DB, ctx context.Context) error {
+	defer UpdateTableTimes(db, ctx, "questions")
+
+	sites, err := QuestionSites(db)
+	if err != nil {
+		return err
+	}
+
+	// Every site's snapshots share the same time, so they can be totalled by tag
+	taken := time.Now()
+	for _, site := range sites {
+		if err := refreshSiteStatuses(db, site, taken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Updates the status of the questions from site, and snapshots the metrics of those returned as they were at taken
+// Questions in batches that could not be fetched are left as they are, and the batch error is returned once the rest are updated
+func refreshSiteStatuses(db *sql.DB, site string, taken time.Time) error {
+	stored, err := readStatuses(db, site)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(stored))
+	for id := range stored {
+		ids = append(ids, id)
+	}
+
+	// Only the closed, locked and migrated fields and the metrics are requested, leaving out the bodies
+	params := make(stackongo.Params)
+	params.Pagesize(100)
+	params.Add("site", site)
+
+	questions, failed, fetchErr := dataCollect.GetQuestionStatusesByIDs(client, ids, appInfo, params)
+
+	// Questions not returned by SE have been deleted, unless their batch failed
+	current := make(map[int]upstreamStatus)
+	for _, id := range ids {
+		current[id] = upstreamStatus{StatusDeleted, ""}
+	}
+	for _, batch := range failed {
+		for _, id := range batch.IDs {
+			delete(current, id)
+		}
+	}
+	for _, item := range questions.Items {
+		current[item.Question_id] = statusOf(item)
+	}
+	if err := addSnapshots(db, site, taken, questions.Items); err != nil {
+		return err
+	}
+
+	// Newly closed duplicates keep their old status until they are linked, so linking is retried on the next sync
+	// Duplicates StackExchange names no original question for are stored as closed, and not retried.
+	duplicates := make(map[int]upstreamStatus)
+	duplicateIDs := []int{}
+	for id, status := range current {
+		if status.status != StatusDuplicate || status == stored[id] {
+			continue
+		}
+		if stored[id] == (upstreamStatus{StatusClosed, status.reason}) {
+			current[id] = stored[id]
+			continue
+		}
+		duplicates[id] = status
+		duplicateIDs = append(duplicateIDs, id)
+		delete(current, id)
+	}
+	if len(duplicateIDs) > 0 {
+		linked, unlinked, err := linkDuplicates(db, site, duplicateIDs, stored)
+		for _, id := range linked {
+			current[id] = duplicates[id]
+		}
+		for _, id := range unlinked {
+			current[id] = upstreamStatus{StatusClosed, duplicates[id].reason}
+		}
+		if err != nil && fetchErr == nil {
+			fetchErr = err
+		}
+	}
+
+	for id, status := range current {
+		if status == stored[id] {
+			continue
+		}
+		if err := setUpstreamStatus(db, site, id, status); err != nil {
+			return err
+		}
+	}
+	return fetchErr
+}
+
+// Shows a hidden question on site again, whatever its status on StackExchange
+func RestoreQuestion(db *sql.DB, ctx context.Context, site string, id int) error {
+	if _, err := db.Exec("UPDATE questions SET hidden=0 WHERE site=? AND question_id=?", site, id); err != nil {
+		return fmt.Errorf("Restore failed: %v", err.Error())
+	}
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
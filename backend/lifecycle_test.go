@@ -0,0 +1,74 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Answers the status query with the stored status of each question, by question id
+func statusAnswer(stored map[int64]string) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.HasPrefix(query, "SELECT question_id, upstream_status") {
+			return nil, nil, nil
+		}
+		rows := [][]driver.Value{}
+		for id, status := range stored {
+			reason := ""
+			if status == StatusClosed {
+				reason = "off-topic"
+			}
+			rows = append(rows, []driver.Value{id, status, reason})
+		}
+		return []string{"question_id", "upstream_status", "upstream_reason"}, rows, nil
+	}
+}
+
+// Returns the status each question was updated to, by question id
+func statusUpdates(fake *fakeDB) map[int64]string {
+	updates := make(map[int64]string)
+	for _, s := range fake.Statements {
+		if strings.HasPrefix(s.Query, "UPDATE questions SET hidden=IF") {
+			updates[s.Args[6].(int64)] = s.Args[2].(string)
+		}
+	}
+	return updates
+}
+
+func TestRefreshSiteStatusesSoftDeletes(t *testing.T) {
+	// Question 2 is no longer returned, and question 3 is closed but was restored by the team
+	_, restore := useAPI(func(path string, query url.Values) (int, string) {
+		return 200, `{"items":[{"question_id":1},{"question_id":3,"closed_date":5,"closed_reason":"off-topic"}]}`
+	})
+	defer restore()
+	db, fake := openFakeDB(t, statusAnswer(map[int64]string{1: StatusOpen, 2: StatusOpen, 3: StatusClosed}))
+	defer db.Close()
+
+	if err := refreshSiteStatuses(db, "stackoverflow", time.Unix(1000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// Only the missing question changes, and it is marked deleted rather than removed
+	if updates := statusUpdates(fake); len(updates) != 1 || updates[2] != StatusDeleted {
+		t.Errorf("updated statuses %v, want only question 2 deleted", updates)
+	}
+	for _, s := range fake.Statements {
+		if strings.HasPrefix(s.Query, "DELETE") {
+			t.Errorf("removed rows with %v", s.Query)
+		}
+	}
+}
+
+func TestRestoreQuestion(t *testing.T) {
+	db, fake := openFakeDB(t, nil)
+	defer db.Close()
+	if err := RestoreQuestion(db, context.Background(), "stackoverflow", 3); err != nil {
+		t.Fatal(err)
+	}
+	if restored := statementArgs(fake, "UPDATE questions SET hidden=0"); restored == nil || restored[0] != "stackoverflow" || restored[1] != int64(3) {
+		t.Errorf("restored %v, want question 3 shown", restored)
+	}
+}
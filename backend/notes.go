@@ -0,0 +1,222 @@
+package backend
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Longest note the team can leave, in characters
+const noteLength = 10000
+
+// Returned for notes with nothing in them
+var ErrEmptyNote = errors.New("Note is empty")
+
+// A note left by a team member on a question, only shown to team members
+type Note struct {
+	ID       int
+	UserID   int           // Id of the team member who wrote it
+	UserName string        // Display name of the team member
+	Body     string        // The note as written, in Markdown
+	HTML     template.HTML // The note rendered from Markdown
+	Created  int64         // When it was written
+}
+
+// Adds a note written by a team member, in Markdown, to a question on site
+func AddNote(db *sql.DB, ctx context.Context, site string, id int, userID int, body string) error {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return ErrEmptyNote
+	}
+	_, err := db.Exec("INSERT INTO question_note(site, question_id, user_id, body, created) VALUES (?, ?, ?, ?, ?)",
+		site, id, userID, truncate(body, noteLength), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Note insertion failed: %v", err.Error())
+	}
+	applog.Infof(ctx, "Note added to question %v on %v by user %v", id, site, userID)
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
+
+// Returns the notes on a question on site, oldest first, rendered from Markdown
+func ReadNotes(db *sql.DB, site string, id int) ([]Note, error) {
+	key := QuestionKey{site, id}
+	notes, err := ReadNotesOf(db, []QuestionKey{key})
+	return notes[key], err
+}
+
+// Returns the notes on each of the questions with keys, oldest first, rendered from Markdown, in one query
+func ReadNotesOf(db *sql.DB, keys []QuestionKey) (map[QuestionKey][]Note, error) {
+	notes := make(map[QuestionKey][]Note)
+	if len(keys) == 0 {
+		return notes, nil
+	}
+	inKeys, args := keysIn("question_note", keys)
+	rows, err := db.Query("SELECT question_note.site, question_note.question_id, question_note.id, question_note.user_id, user.name, "+
+		"question_note.body, question_note.created FROM question_note LEFT JOIN user ON question_note.user_id=user.id "+
+		"WHERE "+inKeys+" ORDER BY question_note.created, question_note.id", args...)
+	if err != nil {
+		return notes, fmt.Errorf("Note query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key  QuestionKey
+			n    Note
+			name sql.NullString
+		)
+		if err := rows.Scan(&key.Site, &key.ID, &n.ID, &n.UserID, &name, &n.Body, &n.Created); err != nil {
+			return notes, fmt.Errorf("Note scan failed: %v", err.Error())
+		}
+		n.UserName = name.String
+		n.HTML = RenderMarkdown(n.Body)
+		notes[key] = append(notes[key], n)
+	}
+	return notes, rows.Err()
+}
+
+// Markdown understood in notes
+var (
+	headingLine  = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletLine   = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	numberedLine = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	quoteLine    = regexp.MustCompile(`^>\s?(.*)$`)
+	codeSpan     = regexp.MustCompile("`([^`]+)`")
+	linkSpan     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	strongSpan   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	emSpan       = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+// Returns the HTML of a note written in Markdown
+// Only headings, lists, quotes, code, links, bold and italics are rendered. Everything else in the note
+// is escaped, including any HTML, so the only markup is what the Markdown makes.
+func RenderMarkdown(markdown string) template.HTML {
+	var (
+		b         bytes.Buffer
+		paragraph []string
+		list      string // Tag of the list being written, if any
+		inCode    bool
+	)
+	endParagraph := func() {
+		if len(paragraph) > 0 {
+			b.WriteString("<p>" + strings.Join(paragraph, "<br>") + "</p>")
+			paragraph = nil
+		}
+	}
+	endList := func() {
+		if list != "" {
+			b.WriteString("</" + list + ">")
+			list = ""
+		}
+	}
+	startList := func(tag string) {
+		endParagraph()
+		if list != tag {
+			endList()
+			b.WriteString("<" + tag + ">")
+			list = tag
+		}
+	}
+
+	for _, line := range strings.Split(strings.Replace(markdown, "\r\n", "\n", -1), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				b.WriteString("</code></pre>")
+			} else {
+				endParagraph()
+				endList()
+				b.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			b.WriteString(HTMLEscapeString(line) + "\n")
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		if m := headingLine.FindStringSubmatch(line); m != nil {
+			endParagraph()
+			endList()
+			// Headings are kept below the question titles they are shown under
+			level := len(m[1]) + 3
+			if level > 6 {
+				level = 6
+			}
+			b.WriteString(fmt.Sprintf("<h%d>%v</h%d>", level, renderInline(m[2]), level))
+		} else if m := bulletLine.FindStringSubmatch(line); m != nil {
+			startList("ul")
+			b.WriteString("<li>" + renderInline(m[1]) + "</li>")
+		} else if m := numberedLine.FindStringSubmatch(line); m != nil {
+			startList("ol")
+			b.WriteString("<li>" + renderInline(m[1]) + "</li>")
+		} else if m := quoteLine.FindStringSubmatch(line); m != nil {
+			endParagraph()
+			endList()
+			b.WriteString("<blockquote>" + renderInline(m[1]) + "</blockquote>")
+		} else if line == "" {
+			endParagraph()
+			endList()
+		} else {
+			endList()
+			paragraph = append(paragraph, renderInline(line))
+		}
+	}
+	if inCode {
+		b.WriteString("</code></pre>")
+	}
+	endParagraph()
+	endList()
+	return template.HTML(b.String())
+}
+
+// Returns the HTML of one line of Markdown, with code spans kept as written
+func renderInline(line string) string {
+	var b bytes.Buffer
+	last := 0
+	for _, span := range codeSpan.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(renderText(line[last:span[0]]))
+		b.WriteString("<code>" + HTMLEscapeString(line[span[2]:span[3]]) + "</code>")
+		last = span[1]
+	}
+	b.WriteString(renderText(line[last:]))
+	return b.String()
+}
+
+// Returns the HTML of Markdown text outside code spans
+func renderText(text string) string {
+	escaped := HTMLEscapeString(text)
+	var b bytes.Buffer
+	last := 0
+	for _, span := range linkSpan.FindAllStringSubmatchIndex(escaped, -1) {
+		href := escaped[span[4]:span[5]]
+		// Only web and mail links are made, so a link cannot run script
+		lower := strings.ToLower(html.UnescapeString(href))
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "mailto:") {
+			continue
+		}
+		// Emphasis is rendered in the text of links but never in their href
+		b.WriteString(renderEmphasis(escaped[last:span[0]]))
+		b.WriteString(`<a href="` + href + `" target="_blank" rel="noopener noreferrer">` + renderEmphasis(escaped[span[2]:span[3]]) + `</a>`)
+		last = span[1]
+	}
+	b.WriteString(renderEmphasis(escaped[last:]))
+	return b.String()
+}
+
+// Returns escaped text with its strong and emphasised spans rendered
+func renderEmphasis(escaped string) string {
+	escaped = strongSpan.ReplaceAllString(escaped, "<strong>$1</strong>")
+	return emSpan.ReplaceAllString(escaped, "<em>$1</em>")
+}
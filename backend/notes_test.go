@@ -0,0 +1,52 @@
+package backend
+
+import "testing"
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"paragraph", "Asked the **maps** team\nabout *quotas*", "<p>Asked the <strong>maps</strong> team<br>about <em>quotas</em></p>"},
+		{"heading", "# Cause", "<h4>Cause</h4>"},
+		{"lists", "- one\n- two\n1. first", "<ul><li>one</li><li>two</li></ul><ol><li>first</li></ol>"},
+		{"quote", "> from the docs", "<blockquote>from the docs</blockquote>"},
+		{"code span", "Call `setBounds(<b>)`", "<p>Call <code>setBounds(&lt;b&gt;)</code></p>"},
+		{"code block", "```\n<script>alert(1)</script>\n```", "<pre><code>&lt;script&gt;alert(1)&lt;/script&gt;\n</code></pre>"},
+		{"web link", "[docs](https://developers.google.com/places)",
+			`<p><a href="https://developers.google.com/places" target="_blank" rel="noopener noreferrer">docs</a></p>`},
+		{"mail link", "[us](mailto:team@example.com)", `<p><a href="mailto:team@example.com" target="_blank" rel="noopener noreferrer">us</a></p>`},
+
+		// Markup in notes is shown as text, not stripped
+		{"html", "<div>hi</div>", "<p>&lt;div&gt;hi&lt;/div&gt;</p>"},
+		{"script", `<script>alert("x")</script>`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>"},
+		{"event handler", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+
+		// Only web and mail links are made
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"upper case javascript link", "[x](JavaScript:alert(1))", "<p>[x](JavaScript:alert(1))</p>"},
+		{"data link", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>[x](data:text/html;base64,PHNjcmlwdD4=)</p>"},
+		{"entity encoded javascript link", "[x](javascript&#58;alert&#40;1&#41;)", "<p>[x](javascript&amp;#58;alert&amp;#40;1&amp;#41;)</p>"},
+		{"entity encoded web link", "[x](&#104;ttps://example.com)", "<p>[x](&amp;#104;ttps://example.com)</p>"},
+
+		// Quotes in links cannot end the href
+		{"quote breaking link", `[x](https://example.com/"onmouseover="alert(1))`,
+			`<p><a href="https://example.com/&#34;onmouseover=&#34;alert(1" target="_blank" rel="noopener noreferrer">x</a>)</p>`},
+		{"single quote link", `[x](https://example.com/'onclick='alert)`,
+			`<p><a href="https://example.com/&#39;onclick=&#39;alert" target="_blank" rel="noopener noreferrer">x</a></p>`},
+		{"markup in link text", "[<b>x</b>](https://example.com)",
+			`<p><a href="https://example.com" target="_blank" rel="noopener noreferrer">&lt;b&gt;x&lt;/b&gt;</a></p>`},
+
+		// Emphasis is never rendered inside an href
+		{"emphasis in link", "[x](http://a*b*c)", `<p><a href="http://a*b*c" target="_blank" rel="noopener noreferrer">x</a></p>`},
+		{"strong in link", "[x](http://a**b**c)", `<p><a href="http://a**b**c" target="_blank" rel="noopener noreferrer">x</a></p>`},
+		{"emphasis around link", "*see* [the **docs**](https://example.com/*a*)",
+			`<p><em>see</em> <a href="https://example.com/*a*" target="_blank" rel="noopener noreferrer">the <strong>docs</strong></a></p>`},
+	}
+	for _, test := range tests {
+		if got := string(RenderMarkdown(test.markdown)); got != test.want {
+			t.Errorf("%v: RenderMarkdown(%q)\n got %v\nwant %v", test.name, test.markdown, got, test.want)
+		}
+	}
+}
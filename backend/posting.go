@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Returned when a user has no stored access token to post with, and must log in again
+var ErrNoAccessToken = errors.New("No access token stored, log in again to post")
+
+// Reason recorded for questions moved to answered by posting an answer from the tracker
+const postedReason = "Answered from the tracker"
+
+// Returns the access token of the user posting
+func postingToken(db *sql.DB, userID int) (string, error) {
+	token, err := ReadAccessToken(db, userID)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", ErrNoAccessToken
+	}
+	return token, nil
+}
+
+// Posts an answer to a question on site as a team member, with their stored access token
+// The answer is stored, and the question moves to the workflow's answered state with the member as its owner and the answer recorded on it.
+// Returns ErrTransitionNotAllowed without posting if the workflow does not let the question move to answered from its state.
+// Once the answer is live its id is returned without an error, and failures to store it or move the question are
+// only logged, so the member is not asked to post it again.
+func PostAnswer(db *sql.DB, ctx context.Context, site string, questionID int, userID int, body string) (int, error) {
+	var from string
+	if err := db.QueryRow("SELECT state FROM questions WHERE site=? AND question_id=?", site, questionID).Scan(&from); err != nil {
+		return 0, fmt.Errorf("State query failed: %v", err.Error())
+	}
+	if from != workflow.Answered && !workflow.Allowed(from, workflow.Answered) {
+		return 0, ErrTransitionNotAllowed
+	}
+	token, err := postingToken(db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	params := make(stackongo.Params)
+	params.Add("site", site)
+	answer, err := dataCollect.AddAnswer(client, questionID, body, token, appInfo, params)
+	if err != nil {
+		return 0, err
+	}
+	applog.Infof(ctx, "Answer %v posted to question %v on %v", answer.Answer_id, questionID, site)
+
+	if err := AddAnswers(db, ctx, site, []stackongo.Answer{answer}); err != nil {
+		applog.Errorf(ctx, "Answer %v posted but not stored: %v", answer.Answer_id, err.Error())
+	}
+	if err := markAnswered(db, ctx, site, questionID, from, userID, answer.Answer_id); err != nil {
+		applog.Errorf(ctx, "Answer %v posted but question %v on %v not moved to %v: %v", answer.Answer_id, questionID, site, workflow.Answered, err.Error())
+	}
+	return answer.Answer_id, nil
+}
+
+// Moves a question on site from the state from to the answered state, owned by the member who posted answerID
+// The move and its history are saved together, and only if the question is still in from.
+// Returns a *ConflictError if the question was moved while the answer was being posted.
+func markAnswered(db *sql.DB, ctx context.Context, site string, questionID int, from string, userID int, answerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Answered transaction failed: %v", err.Error())
+	}
+	now := time.Now().Unix()
+	result, err := tx.Exec("UPDATE questions SET state=?, user=?, time_updated=?, state_reason=?, answer_id=? WHERE site=? AND question_id=? AND state=?",
+		workflow.Answered, userID, now, postedReason, answerID, site, questionID, from)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Answered update failed: %v", err.Error())
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Answered update failed: %v", err.Error())
+	}
+	if n == 0 {
+		tx.Rollback()
+		return readConflict(db, site, questionID)
+	}
+	if from != workflow.Answered {
+		err = RecordTransition(tx, site, questionID, Transition{
+			From: from, To: workflow.Answered, UserID: userID, Reason: postedReason, Time: now,
+		})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Answered commit failed: %v", err.Error())
+	}
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
+
+// Posts a comment on a question or answer on site as a team member, with their stored access token
+// Comments ask for more detail rather than answer, so the question's state is left as it is.
+// Returns the id of the comment posted.
+func PostComment(db *sql.DB, ctx context.Context, site string, postID int, userID int, body string) (int, error) {
+	token, err := postingToken(db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	params := make(stackongo.Params)
+	params.Add("site", site)
+	comment, err := dataCollect.AddComment(client, postID, body, token, appInfo, params)
+	if err != nil {
+		return 0, err
+	}
+	applog.Infof(ctx, "Comment %v posted on post %v on %v", comment.Comment_id, postID, site)
+	return comment.Comment_id, nil
+}
@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Threshold given to watches that do not set one
+const DefaultThreshold = 3
+
+// Points given for each kind of evidence that a question is about a watch
+const (
+	tagPoints        = 3 // The question has one of the watch's tags
+	relatedTagPoints = 1 // One of the question's tags contains a word of a phrase
+	titlePoints      = 3 // The title contains a phrase
+	titleWordsPoints = 1 // The title contains every word of a phrase, but apart
+	mentionPoints    = 1 // The body mentions a phrase, for each mention up to maxMentions
+	codePoints       = 2 // Code in the body refers to a phrase
+	maxMentions      = 3
+	mentionWindow    = 5 // Words of a phrase must be this close together to count as a mention
+	minCodeWord      = 4 // Shorter words, such as "api", are too common in code to count as references
+)
+
+// How relevant a question is to a watch, and why
+type Relevance struct {
+	Score   int
+	Reasons []string
+}
+
+// Adds points to r for a reason
+func (r *Relevance) add(points int, reason string) {
+	r.Score += points
+	r.Reasons = append(r.Reasons, fmt.Sprintf("+%d %v", points, reason))
+}
+
+// Returns a relevance as it is stored in the database, with its reasons joined into one column
+func StoredRelevance(score int, reasons string) *Relevance {
+	return &Relevance{Score: score, Reasons: splitList(reasons)}
+}
+
+// Code blocks and inline code in a question body
+var codeBlocks = regexp.MustCompile(`(?is)<(code|pre)[^>]*>(.*?)</(code|pre)>`)
+
+// Returns the lower case words in text
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the words of a question body, without its markup
+func bodyWords(body string) []string {
+	return words(html.UnescapeString(StripTags(body)))
+}
+
+// Returns the index of phrase as consecutive words in text, or -1 if it is not there
+func indexPhrase(text []string, phrase []string) int {
+	for i := 0; i+len(phrase) <= len(text); i++ {
+		found := true
+		for j, word := range phrase {
+			if text[i+j] != word {
+				found = false
+				break
+			}
+		}
+		if found {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns true if every word of phrase is in text
+func containsWords(text []string, phrase []string) bool {
+	for _, word := range phrase {
+		if !contains(text, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of places in text where every word of phrase is within mentionWindow words of the first
+func countMentions(text []string, phrase []string) int {
+	mentions := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] != phrase[0] {
+			continue
+		}
+		start, end := i-mentionWindow, i+mentionWindow+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(text) {
+			end = len(text)
+		}
+		if containsWords(text[start:end], phrase) {
+			mentions++
+			i = end - 1
+		}
+	}
+	return mentions
+}
+
+// Returns the first word of code that contains phrase, or one of its words of at least minCodeWord letters
+// eg. "placesservice" refers to "places api". Returns "" if there is none
+func codeReference(code []string, phrase []string) string {
+	joined := strings.Join(phrase, "")
+	for _, token := range code {
+		if strings.Contains(token, joined) {
+			return token
+		}
+		for _, word := range phrase {
+			if len(word) >= minCodeWord && strings.Contains(token, word) {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the phrases a watch searches for, as lower case words
+func (w Watch) phrases() [][]string {
+	phrases := [][]string{}
+	for _, phrase := range []string{w.Title, w.Body} {
+		p := words(phrase)
+		if len(p) == 0 {
+			continue
+		}
+		duplicate := false
+		for _, seen := range phrases {
+			if strings.Join(seen, " ") == strings.Join(p, " ") {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			phrases = append(phrases, p)
+		}
+	}
+	return phrases
+}
+
+// Scores how relevant a question is to a watch, using the question's tags, title and body
+// The body must still have its markup, so code in it can be found.
+func ScoreQuestion(item stackongo.Question, w Watch) Relevance {
+	var r Relevance
+	for _, tag := range item.Tags {
+		if contains(w.Tags, tag) {
+			r.add(tagPoints, "tagged "+tag)
+		}
+	}
+
+	title := words(html.UnescapeString(item.Title))
+	body := bodyWords(item.Body)
+	code := []string{}
+	for _, block := range codeBlocks.FindAllStringSubmatch(item.Body, -1) {
+		code = append(code, bodyWords(block[2])...)
+	}
+
+	for _, phrase := range w.phrases() {
+		quoted := fmt.Sprintf("%q", strings.Join(phrase, " "))
+		for _, tag := range item.Tags {
+			if contains(w.Tags, tag) {
+				continue
+			}
+			for _, word := range phrase {
+				if strings.Contains(tag, word) {
+					r.add(relatedTagPoints, "tag "+tag+" is related to "+quoted)
+					break
+				}
+			}
+		}
+
+		if indexPhrase(title, phrase) >= 0 {
+			r.add(titlePoints, "title contains "+quoted)
+		} else if containsWords(title, phrase) {
+			r.add(titleWordsPoints, "title has the words of "+quoted)
+		}
+
+		if mentions := countMentions(body, phrase); mentions > 0 {
+			if mentions > maxMentions {
+				mentions = maxMentions
+			}
+			r.add(mentions*mentionPoints, fmt.Sprintf("body mentions %v %d times", quoted, mentions))
+		}
+
+		if ref := codeReference(code, phrase); ref != "" {
+			r.add(codePoints, "code refers to "+quoted+" in "+ref)
+		}
+	}
+	return r
+}
+
+// Adds new questions from site matched by watches, scoring each against the watches that found it
+// Questions scoring below the threshold of every watch that found them go to the review queue
+// instead of being unanswered. matches maps question ids to the ids of the watches that found them.
+func AddWatchedQuestions(db *sql.DB, ctx context.Context, site string, newQns *stackongo.Questions, watches []Watch, matches map[int][]int) error {
+	byID := make(map[int]Watch)
+	for _, w := range watches {
+		byID[w.ID] = w
+	}
+
+	for _, item := range newQns.Items {
+		// The watch the question did best against, compared to its threshold, gives the score
+		var best Relevance
+		margin, scored := 0, false
+		for _, id := range matches[item.Question_id] {
+			w, ok := byID[id]
+			if !ok {
+				continue
+			}
+			r := ScoreQuestion(item, w)
+			if !scored || r.Score-w.Threshold > margin {
+				best, margin, scored = r, r.Score-w.Threshold, true
+			}
+		}
+
+		// Questions that scored below their watch's threshold wait in review for the team to accept them
+		state := workflow.Initial
+		if scored && margin < 0 {
+			state = workflow.Review
+		}
+		inserted, err := insertQuestion(db, site, item, state, 0)
+		if err != nil {
+			applog.Errorf(ctx, "Error adding question %v: %v", item.Question_id, err.Error())
+			continue
+		}
+		// Questions already tracked keep the score they were added with
+		if !inserted || !scored {
+			continue
+		}
+		if _, err := db.Exec("UPDATE questions SET relevance=?, relevance_reasons=? WHERE site=? AND question_id=?",
+			best.Score, truncate(joinList(best.Reasons), maxReasonsLength), site, item.Question_id); err != nil {
+			applog.Errorf(ctx, "Error scoring question %v: %v", item.Question_id, err.Error())
+		}
+	}
+	UpdateTableTimes(db, ctx, "questions")
+	return nil
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+)
+
+func TestScoreQuestion(t *testing.T) {
+	watch := Watch{Tags: []string{"google-places-api"}, Title: "Places API", Body: "places api"}
+	filler := " one two three four five six "
+	tests := []struct {
+		name    string
+		item    stackongo.Question
+		score   int
+		reasons []string // Every reason, or nil to only check the score
+	}{
+		{
+			"about the watch",
+			stackongo.Question{
+				Tags:  []string{"google-places-api", "javascript"},
+				Title: "Places API returns no results",
+				Body:  "<p>The Places API returns nothing.</p><pre><code>service = new google.maps.places.PlacesService(map);</code></pre>",
+			},
+			9,
+			[]string{
+				"+3 tagged google-places-api",
+				`+3 title contains "places api"`,
+				`+1 body mentions "places api" 1 times`,
+				`+2 code refers to "places api" in places`,
+			},
+		},
+		{
+			"mentioned in passing",
+			stackongo.Question{
+				Tags:  []string{"javascript", "google-maps-api"},
+				Title: "How to center a map",
+				Body:  "<p>I use the places api and the maps api.</p>",
+			},
+			2,
+			[]string{
+				`+1 tag google-maps-api is related to "places api"`,
+				`+1 body mentions "places api" 1 times`,
+			},
+		},
+		{
+			"title words apart",
+			stackongo.Question{Title: "API for nearby places"},
+			1,
+			[]string{`+1 title has the words of "places api"`},
+		},
+		{
+			"mentions capped",
+			stackongo.Question{Body: strings.Repeat("places api"+filler, 5)},
+			3,
+			[]string{`+3 body mentions "places api" 3 times`},
+		},
+		{
+			"escaped title",
+			stackongo.Question{Title: "Places&nbsp;API &quot;ZERO_RESULTS&quot;"},
+			3,
+			nil,
+		},
+		{
+			"short words in code",
+			stackongo.Question{Body: "<code>var api = new Api();</code>"},
+			0,
+			nil,
+		},
+		{
+			"unrelated",
+			stackongo.Question{Tags: []string{"java"}, Title: "NullPointerException", Body: "<p>It crashes</p>"},
+			0,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		r := ScoreQuestion(test.item, watch)
+		if r.Score != test.score {
+			t.Errorf("%v: scored %v %v, want %v", test.name, r.Score, r.Reasons, test.score)
+		}
+		if test.reasons != nil && !reflect.DeepEqual(r.Reasons, test.reasons) {
+			t.Errorf("%v: reasons %q, want %q", test.name, r.Reasons, test.reasons)
+		}
+	}
+}
+
+func TestScoreQuestionTagsOnly(t *testing.T) {
+	watch := Watch{Tags: []string{"google-places-api", "google-places"}}
+	item := stackongo.Question{Tags: []string{"google-places", "google-places-api"}, Title: "Places API"}
+	if r := ScoreQuestion(item, watch); r.Score != 2*tagPoints {
+		t.Errorf("scored %v %v, want %v", r.Score, r.Reasons, 2*tagPoints)
+	}
+}
+
+func TestAddWatchedQuestions(t *testing.T) {
+	defer useWorkflow(t, testWorkflow())()
+	phrase := strings.Repeat("places api ", 150)
+	watch := Watch{ID: 1, Title: phrase, Threshold: DefaultThreshold}
+	questions := &stackongo.Questions{Items: []stackongo.Question{{Question_id: 7, Title: phrase}}}
+	matches := map[int][]int{7: {1}}
+
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{"new question", false},
+		{"question already tracked", true},
+	}
+	for _, test := range tests {
+		db, fake := openFakeDB(t, nil)
+		fake.Affected = func(query string) int64 {
+			if test.exists && strings.HasPrefix(query, "INSERT IGNORE INTO questions") {
+				return 0
+			}
+			return 1
+		}
+		if err := AddWatchedQuestions(db, context.Background(), "stackoverflow", questions, []Watch{watch}, matches); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+		update := statementArgs(fake, "UPDATE questions SET relevance")
+		if test.exists && update != nil {
+			t.Errorf("%v: rescored a question INSERT IGNORE skipped", test.name)
+		}
+		if !test.exists && (update == nil || len([]rune(update[1].(string))) > maxReasonsLength) {
+			t.Errorf("%v: scored with %v, want reasons cut to fit the column", test.name, update)
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,226 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Activity on StackExchange that can trigger a transition rule
+const (
+	EventTeamAnswer        = "team_answer"        // A team member posted an answer to the question
+	EventCommunityAccepted = "community_accepted" // The asker accepted an answer from outside the team
+)
+
+// A transition rule moves questions from any of FromStates to ToState when Event is seen
+type TransitionRule struct {
+	ID         int
+	Name       string
+	Event      string
+	FromStates []string
+	ToState    string
+	Active     bool
+}
+
+// A question matched by a rule's event
+type ruleMatch struct {
+	site       string
+	questionID int
+	state      string
+	userID     int    // Team member credited with the question, 0 for the community
+	reason     string // Why the rule matched
+}
+
+// Returns transition rules from the db filtered by params
+func ReadTransitionRules(db *sql.DB, params string) ([]TransitionRule, error) {
+	rules := []TransitionRule{}
+	query := "SELECT id, name, event, from_states, to_state, active FROM transition_rule"
+	if params != "" {
+		query += " WHERE " + params
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return rules, fmt.Errorf("Rule query failed: %v", err.Error())
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			rule       TransitionRule
+			fromStates string
+		)
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Event, &fromStates, &rule.ToState, &rule.Active); err != nil {
+			return rules, fmt.Errorf("Rule scan failed: %v", err.Error())
+		}
+		rule.FromStates = splitList(fromStates)
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// Adds a new rule, or updates an existing one if rule.ID is set
+func SaveTransitionRule(db *sql.DB, ctx context.Context, rule TransitionRule) error {
+	if rule.Event != EventTeamAnswer && rule.Event != EventCommunityAccepted {
+		return fmt.Errorf("Unknown rule event %v", rule.Event)
+	}
+	for _, state := range append([]string{rule.ToState}, rule.FromStates...) {
+		if !workflow.Valid(state) {
+			return fmt.Errorf("Unknown state %q", state)
+		}
+	}
+	if rule.ID == 0 {
+		_, err := db.Exec("INSERT INTO transition_rule(name, event, from_states, to_state, active) VALUES (?, ?, ?, ?, ?)",
+			rule.Name, rule.Event, joinList(rule.FromStates), rule.ToState, rule.Active)
+		if err != nil {
+			return fmt.Errorf("Rule insertion failed: %v", err.Error())
+		}
+	} else {
+		_, err := db.Exec("UPDATE transition_rule SET name=?, event=?, from_states=?, to_state=?, active=? WHERE id=?",
+			rule.Name, rule.Event, joinList(rule.FromStates), rule.ToState, rule.Active, rule.ID)
+		if err != nil {
+			return fmt.Errorf("Rule update failed: %v", err.Error())
+		}
+	}
+	applog.Infof(ctx, "Rule %v saved", rule.Name)
+	return nil
+}
+
+// Returns the questions in any of the rule's from states that its event has happened to
+func (rule TransitionRule) matches(db *sql.DB) ([]ruleMatch, error) {
+	matches := []ruleMatch{}
+	if len(rule.FromStates) == 0 {
+		return matches, nil
+	}
+
+	args := []interface{}{}
+	for _, state := range rule.FromStates {
+		args = append(args, state)
+	}
+	inStates := "questions.state IN (?" + strings.Repeat(", ?", len(rule.FromStates)-1) + ")"
+
+	var query string
+	switch rule.Event {
+	case EventTeamAnswer:
+		// The earliest answer by a team member since the question last changed state is credited,
+		// so questions reopened after an answer wait for a new one
+		// Team members are recognised by their account on the answer's site
+		inTeam, teamArgs := TeamIn("user.id")
+		args = append(args, teamArgs...)
+		query = "SELECT questions.site, questions.question_id, questions.state, answers.answer_id, user.id, user.name FROM questions " +
+			"JOIN answers ON answers.site=questions.site AND answers.question_id=questions.question_id " +
+			"JOIN user_account ON answers.site=user_account.site AND answers.user_id=user_account.account_id " +
+			"JOIN user ON user_account.user_id=user.id " +
+			"WHERE " + inStates + " AND " + inTeam + " AND answers.creation_date > COALESCE(questions.time_updated, 0) ORDER BY answers.creation_date"
+	case EventCommunityAccepted:
+		inTeam, teamArgs := TeamIn("user_account.user_id")
+		args = append(args, teamArgs...)
+		// Only answers accepted since the question last changed state count, so questions
+		// the team has moved on from are not moved back on every sync
+		query = "SELECT questions.site, questions.question_id, questions.state, answers.answer_id, 0, answers.user_name FROM questions " +
+			"JOIN answers ON answers.site=questions.site AND answers.question_id=questions.question_id " +
+			"WHERE " + inStates + " AND answers.is_accepted=1 AND answers.accepted_date > COALESCE(questions.time_updated, 0) " +
+			"AND NOT EXISTS (SELECT 1 FROM user_account WHERE user_account.site=answers.site AND user_account.account_id=answers.user_id AND " + inTeam + ")"
+	default:
+		return matches, fmt.Errorf("Unknown rule event %v", rule.Event)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return matches, fmt.Errorf("Rule %v query failed: %v", rule.Name, err.Error())
+	}
+	defer rows.Close()
+
+	seen := make(map[QuestionKey]bool)
+	for rows.Next() {
+		var (
+			m        ruleMatch
+			answerID int
+			name     sql.NullString
+		)
+		if err := rows.Scan(&m.site, &m.questionID, &m.state, &answerID, &m.userID, &name); err != nil {
+			return matches, fmt.Errorf("Rule %v scan failed: %v", rule.Name, err.Error())
+		}
+		if seen[QuestionKey{m.site, m.questionID}] {
+			continue
+		}
+		seen[QuestionKey{m.site, m.questionID}] = true
+
+		if rule.Event == EventTeamAnswer {
+			m.reason = fmt.Sprintf("%v: answer %d posted by %v", rule.Name, answerID, name.String)
+		} else {
+			m.reason = fmt.Sprintf("%v: answer %d by %v accepted", rule.Name, answerID, name.String)
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// Moves a question matched by the rule to its to state and records the move in the question's history, together
+// Returns false if the question had already left the state it was matched in.
+func (rule TransitionRule) apply(db *sql.DB, m ruleMatch) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().Unix()
+	result, err := tx.Exec("UPDATE questions SET state=?, user=?, time_updated=?, state_reason=? WHERE site=? AND question_id=? AND state=?",
+		rule.ToState, m.userID, now, m.reason, m.site, m.questionID, m.state)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = RecordTransition(tx, m.site, m.questionID, Transition{
+		From: m.state, To: rule.ToState, UserID: m.userID, RuleID: rule.ID, Reason: m.reason, Time: now,
+	})
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Applies every active transition rule to the questions in the db.
+// Each change is made only if the question is still in the state it was matched in,
+// and is recorded in the question's history along with the reason.
+func ApplyTransitionRules(db *sql.DB, ctx context.Context) error {
+	rules, err := ReadTransitionRules(db, "active=1")
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, rule := range rules {
+		matches, err := rule.matches(db)
+		if err != nil {
+			applog.Errorf(ctx, "%v", err.Error())
+			continue
+		}
+
+		for _, m := range matches {
+			moved, err := rule.apply(db, m)
+			if err != nil {
+				applog.Errorf(ctx, "Rule %v failed for question %v: %v", rule.Name, m.questionID, err.Error())
+				continue
+			}
+			if !moved {
+				continue
+			}
+			changed = true
+			applog.Infof(ctx, "Question %v on %v moved from %v to %v (%v)", m.questionID, m.site, m.state, rule.ToState, m.reason)
+		}
+	}
+
+	if changed {
+		UpdateTableTimes(db, ctx, "questions")
+	}
+	return nil
+}
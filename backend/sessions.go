@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a session lasts after logging in
+const SessionLength = 14 * 24 * time.Hour
+
+// Returned for session cookies that are malformed, badly signed, expired or revoked
+var ErrInvalidSession = errors.New("Invalid session")
+
+// Keys derived from the SESSION_SECRET environment variable by LoadSessionSecret
+var (
+	signingKey []byte // Signs session cookies
+	tokenKey   []byte // Encrypts access tokens
+)
+
+// Derives the signing and encryption keys from the SESSION_SECRET environment variable
+// Every instance has to share the keys to read each other's cookies and stored tokens, so there is no fallback.
+func LoadSessionSecret() error {
+	secret := os.Getenv("SESSION_SECRET")
+	if secret == "" {
+		return errors.New("SESSION_SECRET is not set")
+	}
+	setSessionSecret(secret)
+	return nil
+}
+
+// Derives the signing and encryption keys from secret
+func setSessionSecret(secret string) {
+	signing := sha256.Sum256([]byte("session:" + secret))
+	token := sha256.Sum256([]byte("access_token:" + secret))
+	signingKey, tokenKey = signing[:], token[:]
+}
+
+// Returns the signing and encryption keys
+func keys() ([]byte, []byte) {
+	if signingKey == nil {
+		panic("session keys used before LoadSessionSecret")
+	}
+	return signingKey, tokenKey
+}
+
+// Returns the signature of a session cookie's fields
+func sign(payload string) string {
+	signing, _ := keys()
+	mac := hmac.New(sha256.New, signing)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the hash a session id is stored as, so the ids in the db cannot be used as cookies
+func sessionHash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// Starts a session for a user, returning the signed cookie value and when it expires
+func NewUserSession(db *sql.DB, userID int) (string, time.Time, error) {
+	random := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return "", time.Time{}, fmt.Errorf("Session id failed: %v", err.Error())
+	}
+	id := hex.EncodeToString(random)
+	expires := time.Now().Add(SessionLength)
+
+	_, err := db.Exec("INSERT INTO session(id, user_id, created, expires) VALUES (?, ?, ?, ?)",
+		sessionHash(id), userID, time.Now().Unix(), expires.Unix())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Session insertion failed: %v", err.Error())
+	}
+
+	payload := id + "." + strconv.Itoa(userID) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sign(payload), expires, nil
+}
+
+// Returns the id and user id of the session in a cookie value
+// Returns ErrInvalidSession unless the cookie is signed, unexpired and its session has not been revoked.
+func ReadUserSession(db *sql.DB, value string) (string, int, error) {
+	parts, ok := sessionFields(value)
+	if !ok {
+		return "", 0, ErrInvalidSession
+	}
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, ErrInvalidSession
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", 0, ErrInvalidSession
+	}
+
+	// The db has the final say, so revoked sessions are refused even though their cookies are signed
+	var storedUser int
+	err = db.QueryRow("SELECT user_id FROM session WHERE id=? AND expires>? AND revoked=0",
+		sessionHash(parts[0]), time.Now().Unix()).Scan(&storedUser)
+	if err == sql.ErrNoRows || (err == nil && storedUser != userID) {
+		return "", 0, ErrInvalidSession
+	} else if err != nil {
+		return "", 0, fmt.Errorf("Session query failed: %v", err.Error())
+	}
+	return parts[0], userID, nil
+}
+
+// Returns the id, user id and expiry fields of a session cookie value, and whether it is well formed and signed
+func sessionFields(value string) ([]string, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return nil, false
+	}
+	payload := strings.Join(parts[:3], ".")
+	return parts[:3], hmac.Equal([]byte(sign(payload)), []byte(parts[3]))
+}
+
+// Returns the token posts must carry to act in the session of a cookie value, or "" if the cookie is not signed
+// The token is tied to the session, so pages on other sites cannot know it.
+func CSRFToken(value string) string {
+	parts, ok := sessionFields(value)
+	if !ok {
+		return ""
+	}
+	return sign("csrf." + parts[0])
+}
+
+// Returns true if token is the CSRF token of the session in a cookie value
+func ValidCSRFToken(value string, token string) bool {
+	expected := CSRFToken(value)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(token))
+}
+
+// Revokes one session, when its user logs out
+func RevokeSession(db *sql.DB, id string) error {
+	if _, err := db.Exec("UPDATE session SET revoked=1 WHERE id=?", sessionHash(id)); err != nil {
+		return fmt.Errorf("Session revocation failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Revokes every session of a user, logging them out everywhere
+func RevokeUserSessions(db *sql.DB, userID int) error {
+	if _, err := db.Exec("UPDATE session SET revoked=1 WHERE user_id=?", userID); err != nil {
+		return fmt.Errorf("Session revocation failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Returns the AES-GCM cipher access tokens are encrypted with
+func tokenCipher() (cipher.AEAD, error) {
+	_, token := keys()
+	block, err := aes.NewCipher(token)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Stores a user's access token, encrypted
+func SaveAccessToken(db *sql.DB, userID int, accessToken string) error {
+	gcm, err := tokenCipher()
+	if err != nil {
+		return fmt.Errorf("Access token encryption failed: %v", err.Error())
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("Access token encryption failed: %v", err.Error())
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(accessToken), []byte(strconv.Itoa(userID)))
+
+	_, err = db.Exec("UPDATE user SET access_token=? WHERE id=?", base64.StdEncoding.EncodeToString(sealed), userID)
+	if err != nil {
+		return fmt.Errorf("Access token update failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Returns a user's stored access token, or "" if they have none
+func ReadAccessToken(db *sql.DB, userID int) (string, error) {
+	var stored sql.NullString
+	err := db.QueryRow("SELECT access_token FROM user WHERE id=?", userID).Scan(&stored)
+	if err == sql.ErrNoRows || (err == nil && stored.String == "") {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Access token query failed: %v", err.Error())
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(stored.String)
+	if err != nil {
+		return "", fmt.Errorf("Access token decryption failed: %v", err.Error())
+	}
+	gcm, err := tokenCipher()
+	if err != nil {
+		return "", fmt.Errorf("Access token decryption failed: %v", err.Error())
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("Access token decryption failed: token too short")
+	}
+	token, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(strconv.Itoa(userID)))
+	if err != nil {
+		return "", fmt.Errorf("Access token decryption failed: %v", err.Error())
+	}
+	return string(token), nil
+}
@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Answers session queries as if every session in the db belongs to userID, and is unrevoked
+func sessionAnswer(userID int) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT user_id FROM session") {
+			return []string{"user_id"}, [][]driver.Value{{int64(userID)}}, nil
+		}
+		return nil, nil, nil
+	}
+}
+
+// Returns a cookie value for session id of userID expiring at expires, signed with the current keys
+func signedSession(id string, userID int, expires time.Time) string {
+	payload := id + "." + strconv.Itoa(userID) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "." + sign(payload)
+}
+
+func TestUserSessionRoundTrip(t *testing.T) {
+	setSessionSecret("test secret")
+	db, fake := openFakeDB(t, sessionAnswer(42))
+	defer db.Close()
+
+	value, expires, err := NewUserSession(db, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires.Before(time.Now().Add(SessionLength - time.Minute)) {
+		t.Errorf("session expires at %v, want %v from now", expires, SessionLength)
+	}
+	// Only the hash of the session id is stored
+	id := strings.SplitN(value, ".", 2)[0]
+	if stored := fake.Statements[0].Args[0]; stored != sessionHash(id) || stored == id {
+		t.Errorf("stored session id %v, want its hash", stored)
+	}
+
+	readID, userID, err := ReadUserSession(db, value)
+	if err != nil || readID != id || userID != 42 {
+		t.Errorf("read session %v of user %v (%v), want %v of user 42", readID, userID, err, id)
+	}
+}
+
+func TestReadUserSessionRefuses(t *testing.T) {
+	setSessionSecret("test secret")
+	db, _ := openFakeDB(t, sessionAnswer(42))
+	defer db.Close()
+	valid := signedSession("abc", 42, time.Now().Add(time.Hour))
+
+	setSessionSecret("other secret")
+	otherKey := signedSession("abc", 42, time.Now().Add(time.Hour))
+	setSessionSecret("test secret")
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"empty", ""},
+		{"unsigned", "abc.42." + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		{"other user", strings.Replace(valid, ".42.", ".43.", 1)},
+		{"other key", otherKey},
+		{"expired", signedSession("abc", 42, time.Now().Add(-time.Minute))},
+		{"user not in db", signedSession("abc", 7, time.Now().Add(time.Hour))},
+	}
+	for _, test := range tests {
+		if _, _, err := ReadUserSession(db, test.value); err != ErrInvalidSession {
+			t.Errorf("%v: got %v, want %v", test.name, err, ErrInvalidSession)
+		}
+	}
+	if _, _, err := ReadUserSession(db, valid); err != nil {
+		t.Errorf("valid session refused: %v", err)
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	setSessionSecret("test secret")
+	session := signedSession("abc", 42, time.Now().Add(time.Hour))
+	other := signedSession("def", 42, time.Now().Add(time.Hour))
+
+	token := CSRFToken(session)
+	if token == "" {
+		t.Fatal("no token for a signed session")
+	}
+	if !ValidCSRFToken(session, token) {
+		t.Error("token refused for its own session")
+	}
+	if ValidCSRFToken(other, token) {
+		t.Error("token accepted for another session")
+	}
+	if ValidCSRFToken(session, "") || ValidCSRFToken(session, token[1:]) {
+		t.Error("malformed token accepted")
+	}
+	if CSRFToken("abc.42.1.forged") != "" || ValidCSRFToken("", "") {
+		t.Error("token made for an unsigned session")
+	}
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	setSessionSecret("test secret")
+	var stored driver.Value
+	db, _ := openFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "UPDATE user SET access_token") {
+			stored = args[0]
+		} else if strings.HasPrefix(query, "SELECT access_token") {
+			return []string{"access_token"}, [][]driver.Value{{stored}}, nil
+		}
+		return nil, nil, nil
+	})
+	defer db.Close()
+
+	if err := SaveAccessToken(db, 42, "token(("); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := stored.(string); s == "" || strings.Contains(s, "token((") {
+		t.Errorf("stored %q, want the token encrypted", stored)
+	}
+	if token, err := ReadAccessToken(db, 42); err != nil || token != "token((" {
+		t.Errorf("read %q (%v), want the saved token", token, err)
+	}
+	// Tokens are sealed to their user, so they cannot be moved to another
+	if _, err := ReadAccessToken(db, 43); err == nil {
+		t.Error("token read for another user")
+	}
+}
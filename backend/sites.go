@@ -0,0 +1,98 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// Site users log in through. User ids stored for team members belong to this site, and
+// their accounts on every site are kept in user_account by SaveUserAccounts.
+const LoginSite = dataCollect.DefaultSite
+
+// Identifies a stored question, as question ids are only unique within a site
+type QuestionKey struct {
+	Site string
+	ID   int
+}
+
+// Returns a condition matching the rows of table that belong to any of the questions with keys, and its arguments
+// keys must not be empty
+func keysIn(table string, keys []QuestionKey) (string, []interface{}) {
+	args := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		args = append(args, key.Site, key.ID)
+	}
+	return "(" + table + ".site, " + table + ".question_id) IN ((?, ?)" + strings.Repeat(", (?, ?)", len(keys)-1) + ")", args
+}
+
+// Returns the API name of a StackExchange site from its name, domain or a link to it
+// eg. "stackoverflow.com" returns "stackoverflow" and "http://gis.stackexchange.com/questions/1" returns "gis"
+// An empty name returns the default site
+func SiteName(site string) string {
+	site = strings.ToLower(strings.TrimSpace(site))
+	site = strings.TrimPrefix(strings.TrimPrefix(site, "http://"), "https://")
+	if i := strings.Index(site, "/"); i >= 0 {
+		site = site[:i]
+	}
+	site = strings.TrimPrefix(site, "www.")
+	site = strings.TrimSuffix(site, ".com")
+	site = strings.TrimSuffix(site, ".stackexchange")
+	if site == "" {
+		return dataCollect.DefaultSite
+	}
+	return site
+}
+
+// Returns the session for site, creating it if needed
+func siteSession(site string) *stackongo.Session {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	if _, ok := sessions[site]; !ok {
+		sessions[site] = stackongo.NewSession(site)
+	}
+	return sessions[site]
+}
+
+// Returns the sites that questions are stored from
+func QuestionSites(db *sql.DB) ([]string, error) {
+	sites := []string{}
+	rows, err := db.Query("SELECT DISTINCT site FROM questions")
+	if err != nil {
+		return sites, fmt.Errorf("Site query failed: %v", err.Error())
+	}
+	defer rows.Close()
+	var site string
+	for rows.Next() {
+		if err := rows.Scan(&site); err != nil {
+			return sites, fmt.Errorf("Site scan failed: %v", err.Error())
+		}
+		sites = append(sites, site)
+	}
+	return sites, rows.Err()
+}
+
+// Returns the sites searched by watches, in the order they first appear
+func WatchSites(watches []Watch) []string {
+	sites := []string{}
+	for _, watch := range watches {
+		if !contains(sites, watch.Site) {
+			sites = append(sites, watch.Site)
+		}
+	}
+	return sites
+}
+
+// Returns true if toFind is an element of slice
+func contains(slice []string, toFind string) bool {
+	for _, s := range slice {
+		if s == toFind {
+			return true
+		}
+	}
+	return false
+}
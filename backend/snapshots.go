@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// The metrics of a question, or the totals for a tag, at one sync
+type Snapshot struct {
+	Time      int64
+	Score     int
+	Views     int
+	Answers   int
+	Favorites int
+}
+
+// Stores the metrics of questions from site as they were at taken
+func addSnapshots(db *sql.DB, site string, taken time.Time, items []stackongo.Question) error {
+	stmts, err := db.Prepare("INSERT IGNORE INTO question_snapshot(site, question_id, time, score, view_count, answer_count, favorite_count) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("Snapshot prepare failed: %v", err.Error())
+	}
+	defer stmts.Close()
+
+	for _, item := range items {
+		_, err := stmts.Exec(site, item.Question_id, taken.Unix(), item.Score, item.View_count, item.Answer_count, item.Favorite_count)
+		if err != nil {
+			return fmt.Errorf("Snapshot insertion failed: %v", err.Error())
+		}
+	}
+	return nil
+}
+
+// Returns snapshots read by query, oldest first
+func readSnapshots(db *sql.DB, query string, args ...interface{}) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return snapshots, fmt.Errorf("Snapshot query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Snapshot
+		if err := rows.Scan(&s.Time, &s.Score, &s.Views, &s.Answers, &s.Favorites); err != nil {
+			return snapshots, fmt.Errorf("Snapshot scan failed: %v", err.Error())
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+// Returns the snapshots of a question on site, oldest first
+func ReadSnapshots(db *sql.DB, site string, id int) ([]Snapshot, error) {
+	return readSnapshots(db, "SELECT time, score, view_count, answer_count, favorite_count FROM question_snapshot "+
+		"WHERE site=? AND question_id=? ORDER BY time", site, id)
+}
+
+// Returns the totals of the snapshots of questions with a tag in the family of tag at each sync, oldest first
+// Questions with several tags in the family are counted once, and families are those of each question's site.
+func ReadTagSnapshots(db *sql.DB, tag string) ([]Snapshot, error) {
+	return readSnapshots(db, "SELECT time, SUM(score), SUM(view_count), SUM(answer_count), SUM(favorite_count) FROM question_snapshot "+
+		"WHERE (site, question_id) IN (SELECT question_tag.site, question_tag.question_id FROM question_tag "+
+		"LEFT JOIN tag_family ON question_tag.site=tag_family.site AND question_tag.tag=tag_family.tag "+
+		"WHERE COALESCE(tag_family.family, question_tag.tag)=COALESCE((SELECT family FROM tag_family AS member WHERE member.site=question_tag.site AND member.tag=?), ?)) "+
+		"GROUP BY time ORDER BY time", tag, tag)
+}
+
+// Returns the times team members answered a question on site, oldest first
+func TeamAnswerTimes(db *sql.DB, site string, id int) ([]int64, error) {
+	times := []int64{}
+	inTeam, teamArgs := TeamIn("user_account.user_id")
+	rows, err := db.Query("SELECT answers.creation_date FROM answers JOIN user_account ON answers.site=user_account.site AND answers.user_id=user_account.account_id "+
+		"WHERE answers.site=? AND answers.question_id=? AND "+inTeam+" ORDER BY answers.creation_date", append([]interface{}{site, id}, teamArgs...)...)
+	if err != nil {
+		return times, fmt.Errorf("Team answer query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var t int64
+	for rows.Next() {
+		if err := rows.Scan(&t); err != nil {
+			return times, fmt.Errorf("Team answer scan failed: %v", err.Error())
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
@@ -0,0 +1,71 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+func TestAddSnapshots(t *testing.T) {
+	db, fake := openFakeDB(t, nil)
+	defer db.Close()
+	items := []stackongo.Question{
+		{Question_id: 1, Score: 2, View_count: 30, Answer_count: 1, Favorite_count: 4},
+		{Question_id: 2, Score: -1, View_count: 5},
+	}
+	if err := addSnapshots(db, "stackoverflow", time.Unix(1000, 0), items); err != nil {
+		t.Fatal(err)
+	}
+	// Every question gets a snapshot at the same time, so they can be totalled by tag
+	var got [][]driver.Value
+	for _, s := range fake.Statements {
+		if strings.HasPrefix(s.Query, "INSERT IGNORE INTO question_snapshot") {
+			got = append(got, s.Args)
+		}
+	}
+	want := [][]driver.Value{
+		{"stackoverflow", int64(1), int64(1000), int64(2), int64(30), int64(1), int64(4)},
+		{"stackoverflow", int64(2), int64(1000), int64(-1), int64(5), int64(0), int64(0)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshots %v, want %v", got, want)
+	}
+}
+
+func TestReadSnapshots(t *testing.T) {
+	db, fake := openFakeDB(t, func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		return []string{"time", "score", "view_count", "answer_count", "favorite_count"}, [][]driver.Value{
+			{int64(1000), int64(1), int64(10), int64(0), int64(0)},
+			{int64(2000), int64(3), int64(25), int64(1), int64(2)},
+		}, nil
+	})
+	defer db.Close()
+	snapshots, err := ReadSnapshots(db, "stackoverflow", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Snapshot{{1000, 1, 10, 0, 0}, {2000, 3, 25, 1, 2}}
+	if !reflect.DeepEqual(snapshots, want) {
+		t.Errorf("read %v, want %v", snapshots, want)
+	}
+	if args := statementArgs(fake, "SELECT time"); len(args) != 2 || args[0] != "stackoverflow" || args[1] != int64(7) {
+		t.Errorf("queried snapshots with %v, want question 7 on stackoverflow", args)
+	}
+}
+
+func TestTeamAnswerTimesWithoutTeam(t *testing.T) {
+	defer useTeam("", "")()
+	db, fake := openFakeDB(t, nil)
+	defer db.Close()
+	if _, err := TeamAnswerTimes(db, "stackoverflow", 7); err != nil {
+		t.Fatal(err)
+	}
+	// With no team, no answer counts as the team's
+	if len(fake.Statements) != 1 || !strings.Contains(fake.Statements[0].Query, "AND FALSE") {
+		t.Errorf("queried %v, want a query matching no answers", fake.Statements)
+	}
+}
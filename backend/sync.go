@@ -0,0 +1,209 @@
+package backend
+
+import (
+	"crypto/sha1"
+	"dataCollect"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// A checkpoint records how far a paginated search got before it was stopped,
+// so that the next sync can carry on from the same page and time window.
+type checkpoint struct {
+	fromDate time.Time
+	toDate   time.Time
+	page     int
+}
+
+// Returns a stable key identifying a watch's search, built from its site, its own tags and the term searched for.
+// Tags added from tag families are left out, so editing a family does not restart the watch's searches.
+func queryKey(watch Watch, params stackongo.Params) (string, string) {
+	tags, notTagged := watch.ownTags, watch.ownNotTagged
+	if tags == nil && notTagged == nil {
+		tags, notTagged = watch.Tags, watch.NotTagged
+	}
+	query := fmt.Sprintf("watch=%d&site=%s&tags=%s&nottagged=%s", watch.ID, watch.Site,
+		strings.Join(tags, listSeparator), strings.Join(notTagged, listSeparator))
+	for _, key := range []string{"tagged", "title", "body"} {
+		if value, ok := params[key]; ok {
+			query += "&" + key + "=" + value
+		}
+	}
+	sum := sha1.Sum([]byte(query))
+	return hex.EncodeToString(sum[:]), query
+}
+
+// Returns the saved checkpoint for key, or nil if the last search with key finished
+func readCheckpoint(db *sql.DB, key string) (*checkpoint, error) {
+	var fromDate, toDate int64
+	cp := new(checkpoint)
+	err := db.QueryRow("SELECT from_date, to_date, page FROM sync_checkpoint WHERE query_key=?", key).Scan(&fromDate, &toDate, &cp.page)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Checkpoint query failed: %v", err.Error())
+	}
+	cp.fromDate = time.Unix(fromDate, 0)
+	cp.toDate = time.Unix(toDate, 0)
+	return cp, nil
+}
+
+// Saves the page and time window a search stopped at
+func saveCheckpoint(db *sql.DB, key string, query string, cp checkpoint) error {
+	_, err := db.Exec("INSERT INTO sync_checkpoint(query_key, query, from_date, to_date, page, time_updated) VALUES (?, ?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE from_date=VALUES(from_date), to_date=VALUES(to_date), page=VALUES(page), time_updated=VALUES(time_updated)",
+		key, query, cp.fromDate.Unix(), cp.toDate.Unix(), cp.page, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("Checkpoint save failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Removes the checkpoint for a finished search
+func deleteCheckpoint(db *sql.DB, key string) error {
+	if _, err := db.Exec("DELETE FROM sync_checkpoint WHERE query_key=?", key); err != nil {
+		return fmt.Errorf("Checkpoint delete failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Returns the name of the watermark recording how far a search has been synced
+func queryWatermark(key string) string {
+	return "query_" + key
+}
+
+// Collects the questions for one of a watch's searches up to toDate.
+// The search carries on from its watermark, the toDate of its last finished sync, or from fromDate if it has never been synced.
+// If an earlier sync of the search was stopped, its time window is finished first, starting from the saved page,
+// before the rest of the window up to toDate is searched.
+// When the client stops, the questions collected so far are returned with the error and a checkpoint is saved.
+func collectQuery(db *sql.DB, ctx context.Context, watch Watch, params stackongo.Params, fromDate time.Time, toDate time.Time) (*stackongo.Questions, error) {
+	key, query := queryKey(watch, params)
+	questions := new(stackongo.Questions)
+
+	since, ok, err := readWatermark(db, queryWatermark(key))
+	if err != nil {
+		return questions, err
+	}
+	if ok {
+		fromDate = since
+	}
+
+	cp, err := readCheckpoint(db, key)
+	if err != nil {
+		return questions, err
+	}
+	windows := []checkpoint{{fromDate: fromDate, toDate: toDate, page: 1}}
+	if cp == nil && !fromDate.Before(toDate) {
+		return questions, nil
+	}
+	if cp != nil {
+		windows = []checkpoint{*cp}
+		if cp.toDate.Before(toDate) {
+			windows = append(windows, checkpoint{fromDate: cp.toDate, toDate: toDate, page: 1})
+		}
+	}
+
+	for _, window := range windows {
+		// Adding parameters to request
+		params.Pagesize(100)
+		params.Fromdate(window.fromDate)
+		params.Todate(window.toDate)
+		params.Sort("creation")
+		params.Add("accepted", false)
+		params.Add("closed", false)
+		params.Add("site", watch.Site)
+
+		newQns, nextPage, err := dataCollect.CollectFrom(client, appInfo, params, window.page)
+		newQns.Items = append(questions.Items, newQns.Items...)
+		questions = newQns
+		if err != nil {
+			if nextPage > 0 {
+				window.page = nextPage
+				// The request error is returned rather than the save error, so callers can tell how to retry
+				if saveErr := saveCheckpoint(db, key, query, window); saveErr != nil {
+					applog.Errorf(ctx, "Checkpoint of %v not saved, its search will start over: %v", query, saveErr.Error())
+				}
+			}
+			return questions, err
+		}
+	}
+	if err := saveWatermark(db, queryWatermark(key), toDate); err != nil {
+		return questions, err
+	}
+	return questions, deleteCheckpoint(db, key)
+}
+
+// Returns the time stored for a watermark, and false if it has not been set yet
+func readWatermark(db *sql.DB, name string) (time.Time, bool, error) {
+	var watermark int64
+	err := db.QueryRow("SELECT watermark FROM sync_watermark WHERE name=?", name).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("Watermark query failed: %v", err.Error())
+	}
+	return time.Unix(watermark, 0), true, nil
+}
+
+// Stores the time reached by a sync
+func saveWatermark(db *sql.DB, name string, t time.Time) error {
+	_, err := db.Exec("INSERT INTO sync_watermark(name, watermark) VALUES (?, ?) ON DUPLICATE KEY UPDATE watermark=VALUES(watermark)",
+		name, t.Unix())
+	if err != nil {
+		return fmt.Errorf("Watermark save failed: %v", err.Error())
+	}
+	return nil
+}
+
+// Name of the watermark recording when new questions were last pulled
+const pullWatermark = "pull"
+
+// Claims the next pull for owner, if the last pull finished more than interval ago
+// and no other instance holds an unexpired claim. The claim expires after lease, in case its owner never releases it.
+// Returns false if the pull is not due or is claimed by another instance.
+func ClaimPull(db *sql.DB, owner string, interval time.Duration, lease time.Duration) (bool, error) {
+	// A pull that has never run is due straight away
+	if _, err := db.Exec("INSERT IGNORE INTO sync_watermark(name, watermark) VALUES (?, 0)", pullWatermark); err != nil {
+		return false, fmt.Errorf("Pull claim insertion failed: %v", err.Error())
+	}
+
+	// The update is atomic, so only one instance can take the claim
+	now := time.Now()
+	res, err := db.Exec("UPDATE sync_watermark SET claimed_by=?, claimed_until=? "+
+		"WHERE name=? AND watermark<=? AND (claimed_until IS NULL OR claimed_until<?)",
+		owner, now.Add(lease).Unix(), pullWatermark, now.Add(-interval).Unix(), now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("Pull claim failed: %v", err.Error())
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Pull claim failed: %v", err.Error())
+	}
+	return claimed == 1, nil
+}
+
+// Releases the pull claimed by owner
+// If finished is true the pull is recorded as done, so the next one waits for the full interval,
+// otherwise the next request can claim it again straight away.
+func ReleasePull(db *sql.DB, owner string, finished bool) error {
+	var err error
+	if finished {
+		_, err = db.Exec("UPDATE sync_watermark SET watermark=?, claimed_by=NULL, claimed_until=NULL WHERE name=? AND claimed_by=?",
+			time.Now().Unix(), pullWatermark, owner)
+	} else {
+		_, err = db.Exec("UPDATE sync_watermark SET claimed_by=NULL, claimed_until=NULL WHERE name=? AND claimed_by=?",
+			pullWatermark, owner)
+	}
+	if err != nil {
+		return fmt.Errorf("Pull release failed: %v", err.Error())
+	}
+	return nil
+}
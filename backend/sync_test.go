@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Returns the key of w's search for term, failing the test if w has no such search
+func termKey(t *testing.T, w Watch, term string) string {
+	for _, params := range w.queries() {
+		if _, ok := params[term]; ok {
+			key, _ := queryKey(w, params)
+			return key
+		}
+	}
+	t.Fatalf("no %v search in %+v", term, w)
+	return ""
+}
+
+func TestQueryKeyIgnoresFamilies(t *testing.T) {
+	before := Watch{ID: 3, Site: "stackoverflow", Tags: []string{"maps", "places"}, NotTagged: []string{"ios"}, Body: "quota",
+		ownTags: []string{"maps"}, ownNotTagged: []string{"ios"}}
+	after := before
+	after.Tags = []string{"maps", "places", "directions"}
+	after.NotTagged = []string{"ios", "swift"}
+
+	// A search keeps its key, and so its watermark and checkpoint, when the families of the watch's tags change
+	for _, term := range []string{"tagged", "body"} {
+		if termKey(t, before, term) != termKey(t, after, term) {
+			t.Errorf("%v search key changed with the tag families", term)
+		}
+	}
+
+	// Editing the watch itself starts its searches again
+	edited := after
+	edited.ownTags = []string{"maps", "geocoding"}
+	if termKey(t, after, "body") == termKey(t, edited, "body") {
+		t.Error("search key unchanged after the watch's tags were edited")
+	}
+}
+
+// Answers the checkpoint query of a search with cp, the window and page it stopped at, and nothing else
+func checkpointAnswer(cp []driver.Value) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT from_date, to_date, page FROM sync_checkpoint") {
+			return []string{"from_date", "to_date", "page"}, [][]driver.Value{cp}, nil
+		}
+		return nil, nil, nil
+	}
+}
+
+func TestCollectQueryResumesFailedPage(t *testing.T) {
+	watch := Watch{ID: 3, Site: "stackoverflow", Tags: []string{"maps"}}
+	from, to := time.Unix(1000, 0), time.Unix(2000, 0)
+
+	// The first sync collects page 1, then the API fails on page 2
+	api, restore := useAPI(func(path string, query url.Values) (int, string) {
+		if query.Get("page") == "1" {
+			return 200, `{"items":[{"question_id":1}],"has_more":true}`
+		}
+		return 500, `{"error_id":500,"error_name":"internal_error","error_message":"try again"}`
+	})
+	defer restore()
+	db, fake := openFakeDB(t, nil)
+	questions, err := collectQuery(db, context.Background(), watch, watch.queries()[0], from, to)
+	if err == nil || len(questions.Items) != 1 {
+		t.Fatalf("got %v questions and %v, want the first page and the error", len(questions.Items), err)
+	}
+	cp := statementArgs(fake, "INSERT INTO sync_checkpoint")
+	if cp == nil || cp[2] != int64(1000) || cp[3] != int64(2000) || cp[4] != int64(2) {
+		t.Fatalf("saved checkpoint %v, want page 2 of the window from 1000 to 2000", cp)
+	}
+	if statementArgs(fake, "INSERT INTO sync_watermark") != nil {
+		t.Error("watermark moved past a failed page")
+	}
+	db.Close()
+
+	// The next sync finishes the stopped window from the failed page, then searches up to its own end
+	api.Answer = func(path string, query url.Values) (int, string) {
+		return 200, `{"items":[{"question_id":2}]}`
+	}
+	api.Requests = nil
+	db, fake = openFakeDB(t, checkpointAnswer(cp[2:5]))
+	defer db.Close()
+	questions, err = collectQuery(db, context.Background(), watch, watch.queries()[0], from, time.Unix(3000, 0))
+	if err != nil || len(questions.Items) != 2 {
+		t.Fatalf("got %v questions and %v, want one from each window", len(questions.Items), err)
+	}
+	want := []struct{ page, fromdate, todate string }{{"2", "1000", "2000"}, {"1", "2000", "3000"}}
+	for i, w := range want {
+		if i >= len(api.Requests) {
+			t.Fatalf("sent %v requests, want %v", len(api.Requests), len(want))
+		}
+		q := api.Requests[i].Query()
+		if q.Get("page") != w.page || q.Get("fromdate") != w.fromdate || q.Get("todate") != w.todate {
+			t.Errorf("request %v asked for %v, want page %v from %v to %v", i, q, w.page, w.fromdate, w.todate)
+		}
+	}
+	if watermark := statementArgs(fake, "INSERT INTO sync_watermark"); watermark == nil || watermark[1] != int64(3000) {
+		t.Errorf("saved watermark %v, want 3000", watermark)
+	}
+	if statementArgs(fake, "DELETE FROM sync_checkpoint") == nil {
+		t.Error("checkpoint kept after the search finished")
+	}
+}
+
+// Answers the watermark query of a search with watermark, and nothing else
+func watermarkAnswer(watermark int64) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT watermark FROM sync_watermark") {
+			return []string{"watermark"}, [][]driver.Value{{watermark}}, nil
+		}
+		return nil, nil, nil
+	}
+}
+
+func TestCollectQueryCarriesOnFromWatermark(t *testing.T) {
+	watch := Watch{ID: 3, Site: "stackoverflow", Tags: []string{"maps"}}
+	api, restore := useAPI(func(path string, query url.Values) (int, string) {
+		return 200, `{"items":[{"question_id":1}]}`
+	})
+	defer restore()
+
+	// The search starts where the last sync finished, not at the start of the pull
+	db, fake := openFakeDB(t, watermarkAnswer(1500))
+	if _, err := collectQuery(db, context.Background(), watch, watch.queries()[0], time.Unix(1000, 0), time.Unix(2000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.Requests) != 1 || api.Requests[0].Query().Get("fromdate") != "1500" {
+		t.Errorf("sent %v, want one search from the watermark", api.Requests)
+	}
+	if watermark := statementArgs(fake, "INSERT INTO sync_watermark"); watermark == nil || watermark[1] != int64(2000) {
+		t.Errorf("saved watermark %v, want 2000", watermark)
+	}
+	db.Close()
+
+	// A search already synced up to the end of the pull is not sent
+	api.Requests = nil
+	db, _ = openFakeDB(t, watermarkAnswer(2000))
+	defer db.Close()
+	if _, err := collectQuery(db, context.Background(), watch, watch.queries()[0], time.Unix(1000, 0), time.Unix(2000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.Requests) != 0 {
+		t.Errorf("sent %v, want no search", api.Requests)
+	}
+}
+
+func TestClaimPull(t *testing.T) {
+	for _, free := range []bool{true, false} {
+		db, fake := openFakeDB(t, nil)
+		// The claim only changes the row if no other instance holds it and the pull is due
+		fake.Affected = func(query string) int64 {
+			if strings.HasPrefix(query, "UPDATE sync_watermark SET claimed_by") && !free {
+				return 0
+			}
+			return 1
+		}
+		claimed, err := ClaimPull(db, "instance-1", time.Hour, time.Minute)
+		if err != nil || claimed != free {
+			t.Errorf("claimed %v (%v), want %v", claimed, err, free)
+		}
+		db.Close()
+	}
+}
+
+func TestReleasePull(t *testing.T) {
+	for _, finished := range []bool{true, false} {
+		db, fake := openFakeDB(t, nil)
+		if err := ReleasePull(db, "instance-1", finished); err != nil {
+			t.Fatal(err)
+		}
+		// Only a finished pull moves the watermark, so an unfinished one can be claimed again straight away
+		moved := statementArgs(fake, "UPDATE sync_watermark SET watermark") != nil
+		if moved != finished {
+			t.Errorf("finished %v: moved the watermark %v", finished, moved)
+		}
+		db.Close()
+	}
+}
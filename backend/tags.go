@@ -0,0 +1,239 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Where a tag's family came from
+const (
+	FamilySynonym = "synonym" // Seeded from a StackExchange tag synonym
+	FamilyAdmin   = "admin"   // Set by an admin, and never replaced by synonyms
+)
+
+// Name of the watermark recording when tag families were last seeded from synonyms
+const synonymWatermark = "tag_synonyms"
+
+// How often tag families are seeded from synonyms, as synonyms rarely change
+const synonymInterval = 24 * time.Hour
+
+// A tag on a site and the family it is counted and searched as
+// Tags without a stored family are a family of their own, named after the tag.
+// Families are kept per site, as each site has its own synonyms.
+type TagFamily struct {
+	Site   string
+	Tag    string
+	Family string
+	Source string
+}
+
+// Returns the stored families, ordered by site and family
+func ReadTagFamilies(db *sql.DB) ([]TagFamily, error) {
+	families := []TagFamily{}
+	rows, err := db.Query("SELECT site, tag, family, source FROM tag_family ORDER BY site, family, tag")
+	if err != nil {
+		return families, fmt.Errorf("Tag family query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f TagFamily
+		if err := rows.Scan(&f.Site, &f.Tag, &f.Family, &f.Source); err != nil {
+			return families, fmt.Errorf("Tag family scan failed: %v", err.Error())
+		}
+		families = append(families, f)
+	}
+	return families, rows.Err()
+}
+
+// Returns the family of every tag that has one, by site and then by tag
+func readFamilies(db *sql.DB) (map[string]map[string]string, error) {
+	families := make(map[string]map[string]string)
+	stored, err := ReadTagFamilies(db)
+	for _, f := range stored {
+		if families[f.Site] == nil {
+			families[f.Site] = make(map[string]string)
+		}
+		families[f.Site][f.Tag] = f.Family
+	}
+	return families, err
+}
+
+// Returns the family of tag
+func familyOf(families map[string]string, tag string) string {
+	if family, ok := families[tag]; ok {
+		return family
+	}
+	return tag
+}
+
+// Returns tags with the other tags in each of their families added after them
+func expandTags(families map[string]string, tags []string) []string {
+	expanded := []string{}
+	for _, tag := range tags {
+		family := familyOf(families, tag)
+		for _, member := range append([]string{tag, family}, familyMembers(families, family)...) {
+			if !contains(expanded, member) {
+				expanded = append(expanded, member)
+			}
+		}
+	}
+	return expanded
+}
+
+// Returns the tags with a stored family of family
+func familyMembers(families map[string]string, family string) []string {
+	members := []string{}
+	for tag, f := range families {
+		if f == family {
+			members = append(members, tag)
+		}
+	}
+	return members
+}
+
+// Puts tag in family on site, replacing any family it had. An empty family puts the tag in a family of its own.
+// Tags in the family tag used to name move with it, so families are never nested.
+// Families set this way are kept when families are seeded from synonyms.
+func SaveTagFamily(db *sql.DB, ctx context.Context, site string, tag string, family string) error {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	family = strings.ToLower(strings.TrimSpace(family))
+	if tag == "" {
+		return fmt.Errorf("Tag family update failed: no tag given")
+	}
+	if family == "" {
+		family = tag
+	}
+
+	families, err := readFamilies(db)
+	if err != nil {
+		return err
+	}
+	family = familyOf(families[site], family)
+
+	_, err = db.Exec("INSERT INTO tag_family(site, tag, family, source) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE family=VALUES(family), source=VALUES(source)",
+		site, tag, family, FamilyAdmin)
+	if err != nil {
+		return fmt.Errorf("Tag family update failed: %v", err.Error())
+	}
+	if family != tag {
+		if _, err := db.Exec("UPDATE tag_family SET family=? WHERE site=? AND family=?", family, site, tag); err != nil {
+			return fmt.Errorf("Tag family update failed: %v", err.Error())
+		}
+	}
+	applog.Infof(ctx, "Tag %v on %v put in family %v", tag, site, family)
+	return nil
+}
+
+// Returns the tags of each of the questions with keys, in one query
+func ReadTagsOf(db *sql.DB, keys []QuestionKey) (map[QuestionKey][]string, error) {
+	tags := make(map[QuestionKey][]string)
+	if len(keys) == 0 {
+		return tags, nil
+	}
+	inKeys, args := keysIn("question_tag", keys)
+	rows, err := db.Query("SELECT site, question_id, tag FROM question_tag WHERE "+inKeys, args...)
+	if err != nil {
+		return tags, fmt.Errorf("Tag query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var (
+		key QuestionKey
+		tag string
+	)
+	for rows.Next() {
+		if err := rows.Scan(&key.Site, &key.ID, &tag); err != nil {
+			return tags, fmt.Errorf("Tag scan failed: %v", err.Error())
+		}
+		tags[key] = append(tags[key], tag)
+	}
+	return tags, rows.Err()
+}
+
+// Returns the tags stored for questions and searched by watches, by site
+func siteTags(db *sql.DB) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	rows, err := db.Query("SELECT DISTINCT site, tag FROM question_tag")
+	if err != nil {
+		return tags, fmt.Errorf("Tag query failed: %v", err.Error())
+	}
+	defer rows.Close()
+
+	var site, tag string
+	for rows.Next() {
+		if err := rows.Scan(&site, &tag); err != nil {
+			return tags, fmt.Errorf("Tag scan failed: %v", err.Error())
+		}
+		tags[site] = append(tags[site], tag)
+	}
+	if err := rows.Err(); err != nil {
+		return tags, err
+	}
+
+	watches, err := ReadWatches(db, "")
+	if err != nil {
+		return tags, err
+	}
+	for _, w := range watches {
+		for _, tag := range append(append([]string{}, w.Tags...), w.NotTagged...) {
+			if !contains(tags[w.Site], tag) {
+				tags[w.Site] = append(tags[w.Site], tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// Seeds tag families from the synonyms of the stored and watched tags on each site, at most once per synonymInterval
+// Each synonym joins the family of the tag the site maps it onto. Families set by an admin are left as they are.
+func RefreshTagFamilies(db *sql.DB, ctx context.Context) error {
+	last, ok, err := readWatermark(db, synonymWatermark)
+	if err != nil {
+		return err
+	}
+	if ok && time.Since(last) < synonymInterval {
+		return nil
+	}
+
+	tags, err := siteTags(db)
+	if err != nil {
+		return err
+	}
+	families, err := readFamilies(db)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := db.Prepare("INSERT INTO tag_family(site, tag, family, source) VALUES (?, ?, ?, ?) " +
+		"ON DUPLICATE KEY UPDATE family=IF(source=?, family, VALUES(family))")
+	if err != nil {
+		return fmt.Errorf("Tag family prepare failed: %v", err.Error())
+	}
+	defer stmt.Close()
+
+	for site, names := range tags {
+		params := make(stackongo.Params)
+		params.Pagesize(100)
+		params.Add("site", site)
+		synonyms, err := dataCollect.GetTagSynonyms(client, names, appInfo, params)
+		if err != nil {
+			return err
+		}
+		for _, synonym := range synonyms.Items {
+			family := familyOf(families[site], synonym.To_tag)
+			if _, err := stmt.Exec(site, synonym.From_tag, family, FamilySynonym, FamilyAdmin); err != nil {
+				return fmt.Errorf("Tag family insertion failed: %v", err.Error())
+			}
+		}
+		applog.Infof(ctx, "%v tag synonyms read from %v", len(synonyms.Items), site)
+	}
+	return saveWatermark(db, synonymWatermark, time.Now())
+}
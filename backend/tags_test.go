@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestExpandTags(t *testing.T) {
+	families := map[string]string{
+		"google-places":   "google-places-api",
+		"places":          "google-places-api",
+		"google-maps-api": "google-maps",
+	}
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		// A family brings in its members
+		{[]string{"google-places-api"}, []string{"google-places", "google-places-api", "places"}},
+		// A member brings in its family and the other members
+		{[]string{"places"}, []string{"google-places", "google-places-api", "places"}},
+		// Tags without a family are kept as they are
+		{[]string{"javascript"}, []string{"javascript"}},
+		{[]string{"javascript", "google-maps"}, []string{"google-maps", "google-maps-api", "javascript"}},
+		{[]string{}, []string{}},
+	}
+	for _, test := range tests {
+		got := expandTags(families, test.tags)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("expandTags(%v) = %v, want %v", test.tags, got, test.want)
+		}
+	}
+}
+
+// Answers the tag family query with the families given, and every other statement with nothing
+func familyAnswer(families ...TagFamily) func(string, []driver.Value) ([]string, [][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+		if !strings.HasPrefix(query, "SELECT site, tag, family, source FROM tag_family") {
+			return nil, nil, nil
+		}
+		rows := [][]driver.Value{}
+		for _, f := range families {
+			rows = append(rows, []driver.Value{f.Site, f.Tag, f.Family, f.Source})
+		}
+		return []string{"site", "tag", "family", "source"}, rows, nil
+	}
+}
+
+func TestSaveTagFamily(t *testing.T) {
+	stored := []TagFamily{
+		{"stackoverflow", "gmaps", "google-maps", FamilySynonym},
+		{"gis", "maps", "gis-maps", FamilySynonym},
+	}
+	tests := []struct {
+		name   string
+		tag    string
+		family string
+		want   string // Family the tag is saved in
+		unnest bool   // Whether tags in the tag's own family are moved
+	}{
+		{"new family", "places", "google-places-api", "google-places-api", true},
+		{"own family", "places", "", "places", false},
+		{"member of a family", " Maps ", "gmaps", "google-maps", true},
+		// Families on other sites are left out, gis-maps is only a family on gis
+		{"family on another site", "cartography", "maps", "maps", true},
+	}
+	for _, test := range tests {
+		db, fake := openFakeDB(t, familyAnswer(stored...))
+		if err := SaveTagFamily(db, context.Background(), "stackoverflow", test.tag, test.family); err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		tag := strings.ToLower(strings.TrimSpace(test.tag))
+
+		var inserted, unnested []driver.Value
+		for _, s := range fake.Statements {
+			if strings.HasPrefix(s.Query, "INSERT INTO tag_family") {
+				inserted = s.Args
+			} else if strings.HasPrefix(s.Query, "UPDATE tag_family") {
+				unnested = s.Args
+			}
+		}
+		if want := []driver.Value{"stackoverflow", tag, test.want, FamilyAdmin}; !reflect.DeepEqual(inserted, want) {
+			t.Errorf("%v: inserted %v, want %v", test.name, inserted, want)
+		}
+		if test.unnest {
+			if want := []driver.Value{test.want, "stackoverflow", tag}; !reflect.DeepEqual(unnested, want) {
+				t.Errorf("%v: moved the family's tags with %v, want %v", test.name, unnested, want)
+			}
+		} else if unnested != nil {
+			t.Errorf("%v: moved the family's tags with %v, want them left", test.name, unnested)
+		}
+		db.Close()
+	}
+}
+
+func TestSaveTagFamilyWithoutTag(t *testing.T) {
+	db, fake := openFakeDB(t, familyAnswer())
+	defer db.Close()
+	if err := SaveTagFamily(db, context.Background(), "stackoverflow", " ", "google-maps"); err == nil {
+		t.Error("saved a family for an empty tag")
+	}
+	if len(fake.Statements) != 0 {
+		t.Errorf("ran %v statements, want none", len(fake.Statements))
+	}
+}
@@ -0,0 +1,184 @@
+package backend
+
+import (
+	"dataCollect"
+	"database/sql"
+	"html"
+	"sort"
+	"time"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// Column sizes of the questions table, longer values are cut to fit
+const (
+	titleLength      = 100
+	bodyLength       = 1000
+	maxReasonsLength = 1000
+)
+
+// Name of the watermark recording the last refresh of edited questions
+const activityWatermark = "question_activity"
+
+// Returns s cut to at most n characters
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// Returns the title of a question as it is stored in the database
+func storedTitle(title string) string {
+	return truncate(html.UnescapeString(title), titleLength)
+}
+
+// Returns the body of a question as it is stored in the database
+func storedBody(body string) string {
+	return truncate(html.UnescapeString(StripTags(body)), bodyLength)
+}
+
+// Returns the questions with ids on site that have had activity on StackExchange since the time given
+func GetActiveQuestions(site string, ids []int, since time.Time) (*stackongo.Questions, error) {
+	params := make(stackongo.Params)
+	params.Pagesize(100)
+	params.Sort("activity")
+	params.Add("min", since.Unix())
+	params.Add("site", site)
+
+	questions, _, err := dataCollect.GetQuestionsByIDs(client, ids, appInfo, params)
+	return questions, err
+}
+
+// Returns the stored tags of a question on site
+func readTags(db *sql.DB, site string, id int) ([]string, error) {
+	tags := []string{}
+	rows, err := db.Query("SELECT tag FROM question_tag WHERE site=? AND question_id=?", site, id)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+	var tag string
+	for rows.Next() {
+		if err := rows.Scan(&tag); err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// Returns true if a and b hold the same tags in any order
+func sameTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Updates a stored question's title, body and tags if they have been edited on site.
+// The team's state and owner of the question are left as they are, and the time of the change is recorded.
+// Returns true if the question was changed
+func UpdateUpstreamQuestion(db *sql.DB, site string, item stackongo.Question) (bool, error) {
+	var title, body sql.NullString
+	err := db.QueryRow("SELECT question_title, body FROM questions WHERE site=? AND question_id=?", site, item.Question_id).Scan(&title, &body)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	tags, err := readTags(db, site, item.Question_id)
+	if err != nil {
+		return false, err
+	}
+
+	newTitle, newBody := storedTitle(item.Title), storedBody(item.Body)
+	if title.String == newTitle && body.String == newBody && sameTags(tags, item.Tags) {
+		return false, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE questions SET question_title=?, body=?, upstream_changed=? WHERE site=? AND question_id=?",
+		newTitle, newBody, time.Now().Unix(), site, item.Question_id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM question_tag WHERE site=? AND question_id=?", site, item.Question_id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	for _, tag := range item.Tags {
+		if _, err := tx.Exec("INSERT IGNORE INTO question_tag(site, question_id, tag) VALUES(?, ?, ?)", site, item.Question_id, tag); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// Brings the title, body and tags of tracked questions up to date with edits made on StackExchange.
+// Only questions with activity since the last refresh are requested.
+// The watermark is only moved forward once every active question has been collected.
+func RefreshEditedQuestions(db *sql.DB, ctx context.Context) error {
+	now := time.Now()
+	since, ok, err := readWatermark(db, activityWatermark)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		since = now.Add(-1 * time.Hour * 24 * 7)
+	}
+
+	sites, err := QuestionSites(db)
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+	defer func() {
+		if changed > 0 {
+			applog.Infof(ctx, "%v questions changed on StackExchange", changed)
+			UpdateTableTimes(db, ctx, "questions")
+		}
+	}()
+	for _, site := range sites {
+		ids, err := questionIDs(db, site, "")
+		if err != nil {
+			return err
+		}
+
+		// Apply whatever was collected, even if the client stopped part way
+		questions, err := GetActiveQuestions(site, ids, since)
+		for _, item := range questions.Items {
+			updated, updateErr := UpdateUpstreamQuestion(db, site, item)
+			if updateErr != nil {
+				applog.Errorf(ctx, "Error updating question %v on %v from StackExchange: %v", item.Question_id, site, updateErr.Error())
+				continue
+			}
+			if updated {
+				changed++
+			}
+		}
+		if err != nil {
+			applog.Warningf(ctx, "Refreshing edited questions on %v stopped", site)
+			return err
+		}
+	}
+
+	return saveWatermark(db, activityWatermark, now)
+}
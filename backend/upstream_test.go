@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"database/sql/driver"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// Answers the queries of a refresh of edited questions, with one question tracked and the activity watermark at 1500
+func refreshAnswer(query string, args []driver.Value) ([]string, [][]driver.Value, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT DISTINCT site"):
+		return []string{"site"}, [][]driver.Value{{"stackoverflow"}}, nil
+	case strings.HasPrefix(query, "SELECT question_id FROM questions"):
+		return []string{"question_id"}, [][]driver.Value{{int64(1)}}, nil
+	case strings.HasPrefix(query, "SELECT watermark FROM sync_watermark"):
+		return []string{"watermark"}, [][]driver.Value{{int64(1500)}}, nil
+	}
+	return nil, nil, nil
+}
+
+func TestRefreshEditedQuestionsWatermark(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		moved  bool
+	}{
+		{"refreshed", 200, true},
+		{"failed", 500, false},
+	}
+	for _, test := range tests {
+		api, restore := useAPI(func(path string, query url.Values) (int, string) {
+			if test.status != 200 {
+				return test.status, `{"error_id":500,"error_name":"internal_error","error_message":"try again"}`
+			}
+			return 200, `{"items":[]}`
+		})
+		db, fake := openFakeDB(t, refreshAnswer)
+		err := RefreshEditedQuestions(db, context.Background())
+		if (err == nil) != test.moved {
+			t.Errorf("%v: got %v", test.name, err)
+		}
+		// Only activity since the watermark is asked for, and the watermark only moves once it has all been collected
+		if len(api.Requests) != 1 || api.Requests[0].Query().Get("min") != "1500" {
+			t.Errorf("%v: sent %v, want questions active since the watermark", test.name, api.Requests)
+		}
+		if moved := statementArgs(fake, "INSERT INTO sync_watermark") != nil; moved != test.moved {
+			t.Errorf("%v: moved the watermark %v, want %v", test.name, moved, test.moved)
+		}
+		db.Close()
+		restore()
+	}
+}
@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+	"golang.org/x/net/context"
+	applog "google.golang.org/appengine/log"
+)
+
+// A watch describes one set of StackExchange searches to track.
+// Tags are searched one at a time, and the title and body phrases are searched
+// against questions not carrying any of the tags.
+type Watch struct {
+	ID        int
+	Name      string
+	Tags      []string // Questions tagged with any of these are tracked
+	NotTagged []string // Questions tagged with any of these are never tracked
+	Title     string   // Phrase to search for in question titles
+	Body      string   // Phrase to search for in question bodies
+	Site      string   // StackExchange site to search, eg. "stackoverflow"
+	Active    bool
+	Threshold int // Relevance score below which new questions go to the review queue
+
+	ownTags      []string // Tags as saved on the watch, before tag families were added
+	ownNotTagged []string
+}
+
+// Separator used when storing lists, such as tags, in a single column
+// Matches the separator used by the StackExchange API for vectorized parameters
+const listSeparator = ";"
+
+// Joins a list to be stored in the database
+func joinList(list []string) string {
+	return strings.Join(list, listSeparator)
+}
+
+// Splits a stored list, ignoring empty entries
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, listSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Returns the search parameters for each query needed to cover the watch.
+// Each tag is searched excluding the tags already searched, so that a question is only collected once.
+// The title and body phrases are searched excluding all of the watch's tags.
+func (w Watch) queries() []stackongo.Params {
+	queries := []stackongo.Params{}
+	for i, tag := range w.Tags {
+		params := make(stackongo.Params)
+		params.Add("tagged", tag)
+		if nottagged := append(append([]string{}, w.Tags[:i]...), w.NotTagged...); len(nottagged) > 0 {
+			params.AddVectorized("nottagged", nottagged)
+		}
+		queries = append(queries, params)
+	}
+
+	nottagged := append(append([]string{}, w.Tags...), w.NotTagged...)
+	for _, phrase := range []struct{ field, value string }{{"body", w.Body}, {"title", w.Title}} {
+		if phrase.value == "" {
+			continue
+		}
+		params := make(stackongo.Params)
+		params.Add(phrase.field, phrase.value)
+		if len(nottagged) > 0 {
+			params.AddVectorized("nottagged", nottagged)
+		}
+		queries = append(queries, params)
+	}
+	return queries
+}
+
+// Returns watches from the db filtered by params
+func ReadWatches(db *sql.DB, params string) ([]Watch, error) {
+	watches := []Watch{}
+	query := "SELECT id, name, tags, nottagged, title, body, site, active, threshold FROM watch"
+	if params != "" {
+		query += " WHERE " + params
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return watches, fmt.Errorf("Watch query failed: %v", err.Error())
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			w         Watch
+			tags      sql.NullString
+			nottagged sql.NullString
+			title     sql.NullString
+			body      sql.NullString
+		)
+		if err := rows.Scan(&w.ID, &w.Name, &tags, &nottagged, &title, &body, &w.Site, &w.Active, &w.Threshold); err != nil {
+			return watches, fmt.Errorf("Watch scan failed: %v", err.Error())
+		}
+		w.Tags = splitList(tags.String)
+		w.NotTagged = splitList(nottagged.String)
+		w.Title = title.String
+		w.Body = body.String
+		watches = append(watches, w)
+	}
+	return watches, rows.Err()
+}
+
+// Returns the watches that should be searched on the next pull
+// Their tags include every tag in the same families, so each family is searched and scored as a whole.
+func ActiveWatches(db *sql.DB) ([]Watch, error) {
+	watches, err := ReadWatches(db, "active=1")
+	if err != nil {
+		return watches, err
+	}
+	families, err := readFamilies(db)
+	if err != nil {
+		return watches, err
+	}
+	for i := range watches {
+		watches[i].ownTags = watches[i].Tags
+		watches[i].ownNotTagged = watches[i].NotTagged
+		watches[i].Tags = expandTags(families[watches[i].Site], watches[i].Tags)
+		watches[i].NotTagged = expandTags(families[watches[i].Site], watches[i].NotTagged)
+	}
+	return watches, nil
+}
+
+// Adds a new watch, or updates an existing one if w.ID is set
+func SaveWatch(db *sql.DB, ctx context.Context, w Watch) error {
+	w.Site = SiteName(w.Site)
+	if w.ID == 0 {
+		_, err := db.Exec("INSERT INTO watch(name, tags, nottagged, title, body, site, active, threshold) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			w.Name, joinList(w.Tags), joinList(w.NotTagged), w.Title, w.Body, w.Site, w.Active, w.Threshold)
+		if err != nil {
+			return fmt.Errorf("Watch insertion failed: %v", err.Error())
+		}
+	} else {
+		_, err := db.Exec("UPDATE watch SET name=?, tags=?, nottagged=?, title=?, body=?, site=?, active=?, threshold=? WHERE id=?",
+			w.Name, joinList(w.Tags), joinList(w.NotTagged), w.Title, w.Body, w.Site, w.Active, w.Threshold, w.ID)
+		if err != nil {
+			return fmt.Errorf("Watch update failed: %v", err.Error())
+		}
+	}
+	applog.Infof(ctx, "Watch %v saved", w.Name)
+	return nil
+}
+
+// Records which watches matched each question on site
+// matches maps question ids to the ids of the watches that found them
+func AddQuestionWatches(db *sql.DB, ctx context.Context, site string, matches map[int][]int) error {
+	stmt, err := db.Prepare("INSERT IGNORE INTO question_watch(site, question_id, watch_id) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for qnID, watchIDs := range matches {
+		for _, watchID := range watchIDs {
+			if _, err := stmt.Exec(site, qnID, watchID); err != nil {
+				applog.Errorf(ctx, "Error adding watch %v to question %v: %v", watchID, qnID, err.Error())
+			}
+		}
+	}
+	return nil
+}
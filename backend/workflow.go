@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// A state questions can be in, as defined in the workflow configuration
+type State struct {
+	Name        string   // Name stored in the db, eg. "pending"
+	Label       string   // Name shown on tabs and buttons, eg. "Pending"
+	Description string   // Blurb shown above the questions in the state
+	Owned       bool     // Whether questions in the state belong to the team member who moved them there
+	Transitions []string // States questions can be moved to from this one by hand
+	Action      string   // One of Transitions, offered as a one click button, or "" for none
+	ActionLabel string   // Label of the button, the label of the Action state if not set
+}
+
+// The states questions move through, read from workflow.json
+type Workflow struct {
+	Initial  string  // State new questions are added in
+	Review   string  // State new questions of low relevance are queued in for review
+	Answered string  // State questions move to when an answer is posted from the tracker
+	States   []State // In the order their tabs are shown
+}
+
+// Returned when a question is moved between states the workflow has no transition between
+var ErrTransitionNotAllowed = errors.New("Transition not allowed")
+
+// State names are stored in the db and used in element names split on underscores, so are kept simple
+var stateName = regexp.MustCompile(`^[a-z0-9-]{1,50}$`)
+
+// The workflow in use, set by LoadWorkflow
+var workflow *Workflow
+
+// Reads the workflow from the configuration file at path and puts it in use
+func LoadWorkflow(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Workflow read failed: %v", err.Error())
+	}
+	w := new(Workflow)
+	if err := json.Unmarshal(data, w); err != nil {
+		return fmt.Errorf("Workflow parse failed: %v", err.Error())
+	}
+	if err := w.validate(); err != nil {
+		return fmt.Errorf("Invalid workflow: %v", err.Error())
+	}
+	workflow = w
+	return nil
+}
+
+// Returns the workflow in use
+func CurrentWorkflow() *Workflow {
+	return workflow
+}
+
+// Checks every state named in the workflow is defined, and fills in default action labels
+func (w *Workflow) validate() error {
+	if len(w.States) == 0 {
+		return fmt.Errorf("no states defined")
+	}
+	labels := make(map[string]string)
+	for _, s := range w.States {
+		if !stateName.MatchString(s.Name) {
+			return fmt.Errorf("state name %q must be up to 50 lower case letters, digits or hyphens", s.Name)
+		}
+		if _, ok := labels[s.Name]; ok {
+			return fmt.Errorf("state %v defined twice", s.Name)
+		}
+		labels[s.Name] = s.Label
+	}
+	for _, name := range []string{w.Initial, w.Review, w.Answered} {
+		if _, ok := labels[name]; !ok {
+			return fmt.Errorf("unknown state %q", name)
+		}
+	}
+	for i, s := range w.States {
+		for _, to := range s.Transitions {
+			if _, ok := labels[to]; !ok {
+				return fmt.Errorf("state %v has a transition to unknown state %q", s.Name, to)
+			}
+		}
+		if s.Action != "" && !contains(s.Transitions, s.Action) {
+			return fmt.Errorf("state %v has action %v, which is not one of its transitions", s.Name, s.Action)
+		}
+		if s.Action != "" && s.ActionLabel == "" {
+			w.States[i].ActionLabel = labels[s.Action]
+		}
+	}
+	return nil
+}
+
+// Returns the state with name, and whether it is defined
+func (w *Workflow) State(name string) (State, bool) {
+	for _, s := range w.States {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return State{}, false
+}
+
+// Returns true if name is a defined state
+func (w *Workflow) Valid(name string) bool {
+	_, ok := w.State(name)
+	return ok
+}
+
+// Returns true if questions in the state named belong to a team member
+func (w *Workflow) Owned(name string) bool {
+	s, _ := w.State(name)
+	return s.Owned
+}
+
+// Returns the names of the states whose questions belong to a team member
+func (w *Workflow) OwnedStates() []string {
+	var owned []string
+	for _, s := range w.States {
+		if s.Owned {
+			owned = append(owned, s.Name)
+		}
+	}
+	return owned
+}
+
+// Returns true if questions can be moved from one state to the other by hand
+func (w *Workflow) Allowed(from string, to string) bool {
+	s, ok := w.State(from)
+	return ok && contains(s.Transitions, to)
+}
+
+// Returns the states questions can be moved to from the state named, other than by its action
+func (w *Workflow) Menu(name string) []State {
+	var menu []State
+	s, _ := w.State(name)
+	for _, to := range s.Transitions {
+		if t, ok := w.State(to); ok && to != s.Action {
+			menu = append(menu, t)
+		}
+	}
+	return menu
+}
@@ -0,0 +1,92 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+)
+
+// Returns a workflow of unanswered, pending and answered questions
+func testWorkflow() *Workflow {
+	return &Workflow{
+		Initial:  "unanswered",
+		Review:   "unanswered",
+		Answered: "answered",
+		States: []State{
+			{Name: "unanswered", Label: "Unanswered", Transitions: []string{"pending", "answered"}, Action: "pending"},
+			{Name: "pending", Label: "Pending", Owned: true, Transitions: []string{"answered"}, Action: "answered", ActionLabel: "Done"},
+			{Name: "answered", Label: "Answered", Owned: true, Transitions: []string{"pending"}},
+		},
+	}
+}
+
+func TestWorkflowValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(w *Workflow)
+		err    string // Part of the error, or "" if the workflow is valid
+	}{
+		{"valid", func(w *Workflow) {}, ""},
+		{"no states", func(w *Workflow) { w.States = nil }, "no states"},
+		{"bad state name", func(w *Workflow) { w.States[0].Name = "Un_answered" }, "lower case"},
+		{"state defined twice", func(w *Workflow) { w.States[1].Name = "unanswered" }, "defined twice"},
+		{"unknown initial state", func(w *Workflow) { w.Initial = "new" }, `unknown state "new"`},
+		{"unknown answered state", func(w *Workflow) { w.Answered = "" }, `unknown state ""`},
+		{"unknown transition", func(w *Workflow) { w.States[2].Transitions = []string{"closed"} }, "unknown state \"closed\""},
+		{"action not a transition", func(w *Workflow) { w.States[2].Action = "unanswered" }, "not one of its transitions"},
+	}
+	for _, test := range tests {
+		w := testWorkflow()
+		test.change(w)
+		err := w.validate()
+		if test.err == "" && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: got %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestWorkflowValidateLabelsActions(t *testing.T) {
+	w := testWorkflow()
+	if err := w.validate(); err != nil {
+		t.Fatal(err)
+	}
+	// Actions without a label take the label of the state they move to
+	if s, _ := w.State("unanswered"); s.ActionLabel != "Pending" {
+		t.Errorf("unanswered action labelled %q, want %q", s.ActionLabel, "Pending")
+	}
+	if s, _ := w.State("pending"); s.ActionLabel != "Done" {
+		t.Errorf("pending action labelled %q, want %q", s.ActionLabel, "Done")
+	}
+}
+
+func TestWorkflowAllowed(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{"unanswered", "pending", true},
+		{"unanswered", "answered", true},
+		{"pending", "answered", true},
+		{"answered", "pending", true},
+		{"pending", "unanswered", false},
+		{"answered", "answered", false},
+		{"unknown", "pending", false},
+		{"pending", "unknown", false},
+	}
+	for _, test := range tests {
+		if allowed := w.Allowed(test.from, test.to); allowed != test.allowed {
+			t.Errorf("Allowed(%v, %v) = %v, want %v", test.from, test.to, allowed, test.allowed)
+		}
+	}
+}
+
+func TestLoadWorkflow(t *testing.T) {
+	if err := LoadWorkflow("../workflow.json"); err != nil {
+		t.Fatalf("shipped workflow: %v", err)
+	}
+	if err := LoadWorkflow("../missing.json"); err == nil {
+		t.Error("loaded a missing workflow")
+	}
+}
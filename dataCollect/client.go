@@ -0,0 +1,159 @@
+package dataCollect
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Client sends every request to the StackExchange API.
+// It honours the backoff the API asks for on each method, paces requests
+// and stops before the app's daily quota is used up.
+// Its pacing, backoffs and quota are safe to share between goroutines. Its transport is shared too:
+// SetTransport replaces it for every user of the client, so requests go through the transport set last.
+type Client struct {
+	Interval     time.Duration // Minimum time between two requests
+	MaxWait      time.Duration // Longest backoff the client will sleep through before stopping
+	QuotaReserve int           // Number of requests kept in reserve, the client stops once the quota reaches it
+
+	lock         sync.Mutex
+	transport    http.RoundTripper
+	lastRequest  time.Time
+	backoff      map[string]time.Time // Earliest time each method can be called again
+	quota        int                  // Last quota_remaining seen, -1 if not known yet
+	stoppedUntil time.Time            // Time the client can send requests again after a stop
+	stopReason   error
+}
+
+// Reports why the client refused to send a request
+type StopError struct {
+	Kind   ErrorKind // ErrThrottle when backed off, ErrQuota when the quota reserve was reached
+	Method string    // Method being called when the client stopped
+	Reason string    // Why the client stopped
+	Until  time.Time // When requests can be sent again
+}
+
+func (e *StopError) Error() string {
+	return fmt.Sprintf("StackExchange requests stopped at %v: %v (until %v)", e.Method, e.Reason, e.Until.Format(time.RFC3339))
+}
+
+// Creates a client with the default pacing and quota reserve
+func NewClient() *Client {
+	return &Client{
+		Interval:     200 * time.Millisecond,
+		MaxWait:      10 * time.Second,
+		QuotaReserve: 10,
+		backoff:      make(map[string]time.Time),
+		quota:        -1,
+	}
+}
+
+// Sets the transport used to send requests, including requests other goroutines are about to send
+func (c *Client) SetTransport(transport http.RoundTripper) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.transport = transport
+}
+
+// Returns the last quota_remaining reported by the API, or -1 if unknown
+func (c *Client) QuotaRemaining() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.quota
+}
+
+// Returns the reason the client last stopped, or nil if it is able to send requests
+func (c *Client) Stopped() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if time.Now().After(c.stoppedUntil) {
+		return nil
+	}
+	return c.stopReason
+}
+
+// Ids and tags in paths, eg. "questions/1;2;3/answers", are replaced so that
+// backoffs are tracked per method rather than per request.
+var (
+	pathIDs  = regexp.MustCompile(`/[0-9;]+`)
+	pathTags = regexp.MustCompile(`^tags/[^/]+/`)
+)
+
+// Returns the API method a request path belongs to
+func method(path string) string {
+	return pathIDs.ReplaceAllString(pathTags.ReplaceAllString(path, "tags/{tags}/"), "/{ids}")
+}
+
+// Waits until a request to path is allowed, then sends it and parses the response into collection.
+// Returns the wrapper fields of the response, such as whether there are more pages.
+// Returns a *StopError without sending anything if the quota reserve has been reached or
+// the method is backed off for longer than MaxWait, and an *APIError if the API reported an error.
+func (c *Client) get(path string, params map[string]string, collection interface{}) (wrapper, error) {
+	return c.send(path, params, collection, get)
+}
+
+// Waits until a write to path is allowed, then posts params as a form and parses the response into collection.
+// Writes are paced, backed off and stopped the same way as get.
+func (c *Client) post(path string, params map[string]string, collection interface{}) (wrapper, error) {
+	return c.send(path, params, collection, post)
+}
+
+// Sends a request to path with request once it is allowed, and records the backoff and quota of the response
+func (c *Client) send(path string, params map[string]string, collection interface{},
+	request func(http.RoundTripper, string, map[string]string, interface{}) (wrapper, error)) (wrapper, error) {
+	m := method(path)
+
+	c.lock.Lock()
+	now := time.Now()
+	if now.Before(c.stoppedUntil) {
+		err := c.stopReason
+		c.lock.Unlock()
+		return wrapper{}, err
+	}
+
+	// Wait for the method's backoff and the pacing interval
+	wait := c.lastRequest.Add(c.Interval).Sub(now)
+	if until, ok := c.backoff[m]; ok {
+		if backoff := until.Sub(now); backoff > c.MaxWait {
+			c.lock.Unlock()
+			return wrapper{}, &StopError{Kind: ErrThrottle, Method: m, Reason: "method is backed off", Until: until}
+		} else if backoff > wait {
+			wait = backoff
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	// The slot is reserved before sleeping, so the lock is not held while waiting for it
+	c.lastRequest = now.Add(wait)
+	transport := c.transport
+	c.lock.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+		// Another request may have stopped the client while this one waited
+		if err := c.Stopped(); err != nil {
+			return wrapper{}, err
+		}
+	}
+
+	w, err := request(transport, path, params, collection)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if w.Backoff > 0 {
+		c.backoff[m] = time.Now().Add(time.Duration(w.Backoff) * time.Second)
+	}
+	if w.Quota_max > 0 {
+		c.quota = w.Quota_remaining
+		if c.quota <= c.QuotaReserve {
+			// The quota is reset at midnight UTC
+			y, mo, d := time.Now().UTC().Date()
+			c.stoppedUntil = time.Date(y, mo, d+1, 0, 0, 0, 0, time.UTC)
+			c.stopReason = &StopError{Kind: ErrQuota, Method: m, Reason: fmt.Sprintf("quota reserve of %v reached", c.QuotaReserve), Until: c.stoppedUntil}
+		}
+	}
+	return w, err
+}
@@ -0,0 +1,106 @@
+package dataCollect
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientPacesRequests(t *testing.T) {
+	client, dir, _ := replayClient(t)
+	defer os.RemoveAll(dir)
+	client.Interval = 100 * time.Millisecond
+	writeFixture(t, dir, "questions", nil, 200, `{"items":[]}`)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := client.get("questions", nil, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 2*client.Interval {
+		t.Errorf("3 requests took %v, want at least %v", elapsed, 2*client.Interval)
+	}
+}
+
+func TestClientStopsWhenBackedOff(t *testing.T) {
+	client, dir, transport := replayClient(t)
+	defer os.RemoveAll(dir)
+	client.MaxWait = time.Second
+	writeFixture(t, dir, "questions/1;2", nil, 200, `{"items":[],"backoff":30}`)
+	writeFixture(t, dir, "questions/3", nil, 200, `{"items":[]}`)
+	writeFixture(t, dir, "tags/go/synonyms", nil, 200, `{"items":[]}`)
+
+	if _, err := client.get("questions/1;2", nil, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	// The backoff is for the method, whatever ids are asked for
+	_, err := client.get("questions/3", nil, &struct{}{})
+	if stop, ok := err.(*StopError); !ok || stop.Kind != ErrThrottle || stop.Method != "questions/{ids}" {
+		t.Fatalf("got %v, want a throttle stop on questions/{ids}", err)
+	}
+	if transport.Requests != 1 {
+		t.Errorf("sent %v requests, want the backed off one not sent", transport.Requests)
+	}
+	// Other methods are not backed off, and the client is not stopped
+	if _, err := client.get("tags/go/synonyms", nil, &struct{}{}); err != nil {
+		t.Errorf("other method failed: %v", err)
+	}
+	if err := client.Stopped(); err != nil {
+		t.Errorf("client stopped: %v", err)
+	}
+}
+
+func TestClientStopsAtQuotaReserve(t *testing.T) {
+	client, dir, transport := replayClient(t)
+	defer os.RemoveAll(dir)
+	writeFixture(t, dir, "questions", nil, 200, `{"items":[],"quota_max":300,"quota_remaining":11}`)
+	writeFixture(t, dir, "answers", nil, 200, `{"items":[],"quota_max":300,"quota_remaining":10}`)
+
+	if _, err := client.get("questions", nil, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Stopped(); err != nil {
+		t.Fatalf("stopped above the reserve: %v", err)
+	}
+	if _, err := client.get("answers", nil, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if client.QuotaRemaining() != 10 {
+		t.Errorf("quota remaining %v, want 10", client.QuotaRemaining())
+	}
+	if KindOf(client.Stopped()) != ErrQuota {
+		t.Fatalf("got %v, want a quota stop", client.Stopped())
+	}
+
+	// Nothing is sent until the quota resets
+	_, err := client.get("questions", nil, &struct{}{})
+	if stop, ok := err.(*StopError); !ok || stop.Kind != ErrQuota || !stop.Until.After(time.Now()) {
+		t.Errorf("got %v, want a quota stop until the reset", err)
+	}
+	if transport.Requests != 2 {
+		t.Errorf("sent %v requests, want 2", transport.Requests)
+	}
+}
+
+func TestClientReturnsAPIErrors(t *testing.T) {
+	client, dir, _ := replayClient(t)
+	defer os.RemoveAll(dir)
+	writeFixture(t, dir, "questions", nil, 400,
+		`{"error_id":400,"error_name":"bad_parameter","error_message":"ids","quota_max":300,"quota_remaining":200}`)
+	writeFixture(t, dir, "answers", nil, 503, `<html>Service Unavailable</html>`)
+
+	_, err := client.get("questions", nil, &struct{}{})
+	if e, ok := err.(*APIError); !ok || e.Kind != ErrBadParams || e.ID != 400 {
+		t.Errorf("got %v, want bad parameters", err)
+	}
+	// The quota of failed requests is still counted
+	if client.QuotaRemaining() != 200 {
+		t.Errorf("quota remaining %v, want 200", client.QuotaRemaining())
+	}
+	_, err = client.get("answers", nil, &struct{}{})
+	if e, ok := err.(*APIError); !ok || e.Kind != ErrServer || !strings.Contains(e.Error(), "HTTP 503") {
+		t.Errorf("got %v, want a server error for HTTP 503", err)
+	}
+}
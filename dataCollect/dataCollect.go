@@ -1,12 +1,12 @@
 package dataCollect
 
 import (
-	"fmt"
-	"net/http"
-
 	"github.com/laktek/Stack-on-Go/stackongo"
 )
 
+// Site searched when a request does not name one
+const DefaultSite = "stackoverflow"
+
 // Details on a StackExchange app
 type AppDetails struct {
 	Client_id       string
@@ -14,78 +14,81 @@ type AppDetails struct {
 	Client_secret   string
 	Key             string
 	Options         map[string]string
-	Filters         string
+	Filters         *FilterCache // Filters for the fields each fetcher requests
 	Quota_remaining int
 }
 
 // Returns questions based on search parameters params
-func Collect(appInfo AppDetails, params stackongo.Params, transport http.RoundTripper) (*stackongo.Questions, error) {
-	// Add standard parameters
-	params = addParams(appInfo, params)
+// If the client stops part way through the pages of results, the questions collected so far
+// are returned along with the client's *StopError.
+func Collect(client *Client, appInfo AppDetails, params stackongo.Params) (*stackongo.Questions, error) {
+	questions, _, err := CollectFrom(client, appInfo, params, 1)
+	return questions, err
+}
 
-	// Search for questions
-	questions, err := searchAdvanced(params, transport)
+// Returns questions based on search parameters params, starting at page
+// Also returns the next page to collect if the search was stopped before the last page, or 0 once complete.
+// Questions collected before the search stopped are returned along with the error, so no pages are lost.
+func CollectFrom(client *Client, appInfo AppDetails, params stackongo.Params, page int) (*stackongo.Questions, int, error) {
+	questions := new(stackongo.Questions)
+	params, err := addParams(client, appInfo, params, QuestionFields)
 	if err != nil {
-		return nil, err
-	}
-	if questions.Error_id != 0 {
-		return nil, fmt.Errorf("%v: %v", questions.Error_name, questions.Error_message)
-	}
-
-	// If there are more pages of search results, repeat search with next page
-	for questions.Has_more && questions.Quota_remaining > 0 {
-		params.Page(questions.Page + 1)
-		nextPage, err := searchAdvanced(params, transport)
-		if err != nil {
-			return nil, err
-		}
-		if nextPage.Error_id != 0 {
-			return nil, fmt.Errorf("%v: %v", nextPage.Error_name, nextPage.Error_message)
-		}
-		nextPage.Items = append(questions.Items, nextPage.Items...)
-		questions = nextPage
+		return questions, page, err
 	}
-	return questions, nil
+	nextPage, err := fetchPages(client, "search/advanced", params, page, questions)
+	return questions, nextPage, err
 }
 
 // Return questions based on ids
-func GetQuestionsByIDs(session *stackongo.Session, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Questions, error) {
-	// Add standard parameters
-	params = addParams(appInfo, params)
+// Batches of 100 ids are requested concurrently. The questions from every batch that succeeded are returned
+// along with the batches that failed and the first of their errors, so callers can tell which ids were not checked.
+// No request is sent for an empty list of ids.
+func GetQuestionsByIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Questions, []FailedBatch, error) {
+	return getQuestions(client, ids, appInfo, params, QuestionFields)
+}
 
-	questions := &stackongo.Questions{Quota_remaining: 2}
-	startIndex, endIndex := 0, 100
-	for endIndex < len(ids) && questions.Quota_remaining > 1 {
-		// Search for questions
-		newSearch, err := session.GetQuestions(ids[startIndex:endIndex], params)
-		if err != nil {
-			return nil, fmt.Errorf("Failed at ln 57 of dataCollect: %v", err.Error())
-		}
-		if questions.Error_id != 0 {
-			return nil, fmt.Errorf("%v: %v", questions.Error_name, questions.Error_message)
-		}
-		newSearch.Items = append(questions.Items, newSearch.Items...)
-		questions = newSearch
-		startIndex = endIndex
-		endIndex += 100
-	}
-	newSearch, err := session.GetQuestions(ids[startIndex:], params)
+// Return the status and metrics of questions based on ids, without their bodies
+// Batches are requested and failed the same way as GetQuestionsByIDs
+func GetQuestionStatusesByIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Questions, []FailedBatch, error) {
+	return getQuestions(client, ids, appInfo, params, StatusFields)
+}
+
+// Returns the questions with ids, with fields, in concurrent batches
+// If the filter cannot be made, every id is returned as failed
+func getQuestions(client *Client, ids []int, appInfo AppDetails, params stackongo.Params, fields Fields) (*stackongo.Questions, []FailedBatch, error) {
+	questions := new(stackongo.Questions)
+	params, err := addParams(client, appInfo, params, fields)
 	if err != nil {
-		return nil, fmt.Errorf("Failed at ln 57 of dataCollect: %v", err.Error())
-	}
-	if questions.Error_id != 0 {
-		return nil, fmt.Errorf("%v: %v", questions.Error_name, questions.Error_message)
+		if len(ids) == 0 {
+			return questions, nil, nil
+		}
+		return questions, []FailedBatch{{ids, err}}, err
 	}
-	newSearch.Items = append(questions.Items, newSearch.Items...)
-	questions = newSearch
+	failed, err := fetchConcurrently(client, "questions/%v", ids, params, questions)
+	return questions, failed, err
+}
 
-	return questions, nil
+// Return answers to the questions with ids
+// Ids are requested 100 at a time, collecting every page of answers for each batch
+func GetAnswersByQuestionIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Answers, error) {
+	answers := new(stackongo.Answers)
+	err := fetchByIDs(client, "questions/%v/answers", idStrings(ids), maxIDs, appInfo, params, AnswerFields, answers)
+	return answers, err
 }
 
 // Add standard parameters
-func addParams(appInfo AppDetails, params stackongo.Params) stackongo.Params {
+// Requests default to DefaultSite and the filter for fields unless a site or filter has already been set
+func addParams(client *Client, appInfo AppDetails, params stackongo.Params, fields Fields) (stackongo.Params, error) {
 	params.Add("key", appInfo.Key)
-	params.Add("filter", appInfo.Filters)
-	params.Add("site", "stackoverflow")
-	return params
+	if _, ok := params["filter"]; !ok {
+		filter, err := appInfo.Filters.Filter(client, appInfo, fields)
+		if err != nil {
+			return params, err
+		}
+		params.Add("filter", filter)
+	}
+	if _, ok := params["site"]; !ok {
+		params.Add("site", DefaultSite)
+	}
+	return params, nil
 }
@@ -0,0 +1,115 @@
+package dataCollect
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Kinds of error returned when a request to the StackExchange API fails
+// Each kind calls for a different response from the caller
+type ErrorKind int
+
+const (
+	ErrUnknown   ErrorKind = iota // Any other failure, such as the request not being sent
+	ErrThrottle                   // Too many requests, retry once the backoff has passed
+	ErrQuota                      // The daily quota is used up, retry after it resets at midnight UTC
+	ErrAuth                       // The access token is missing, invalid or expired, the user must log in again
+	ErrBadParams                  // The request was malformed, retrying will not help and an admin should be told
+	ErrServer                     // StackExchange failed to handle the request, retry later
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrThrottle:
+		return "throttled"
+	case ErrQuota:
+		return "quota exhausted"
+	case ErrAuth:
+		return "access token rejected"
+	case ErrBadParams:
+		return "bad parameters"
+	case ErrServer:
+		return "server error"
+	}
+	return "unknown error"
+}
+
+// An error returned by the StackExchange API
+type APIError struct {
+	Kind    ErrorKind
+	ID      int    // error_id from the response, or 0 if the response had none
+	Name    string // error_name from the response
+	Message string // error_message from the response
+	Status  int    // HTTP status of the response
+}
+
+func (e *APIError) Error() string {
+	if e.ID == 0 {
+		return fmt.Sprintf("StackExchange %v (HTTP %d %v): %v", e.Kind, e.Status, e.Name, e.Message)
+	}
+	return fmt.Sprintf("StackExchange %v (%d %v): %v", e.Kind, e.ID, e.Name, e.Message)
+}
+
+// Returns the error described by a response's wrapper and HTTP status
+// The error_id is classified when the response has one. Without one, such as when a proxy
+// answers in place of the API, the HTTP status is classified instead, as the two overlap in meaning.
+// See https://api.stackexchange.com/docs/error-handling for the error ids
+func newAPIError(status int, w wrapper) *APIError {
+	e := &APIError{
+		ID:      w.Error_id,
+		Name:    w.Error_name,
+		Message: w.Error_message,
+		Status:  status,
+	}
+	if e.ID == 0 {
+		e.Name = http.StatusText(status)
+		e.Kind = statusKind(status)
+		return e
+	}
+
+	switch e.ID {
+	case 502: // throttle_violation, also returned once the quota is used up
+		if w.Quota_max > 0 && w.Quota_remaining == 0 {
+			e.Kind = ErrQuota
+		} else {
+			e.Kind = ErrThrottle
+		}
+	case 409: // duplicate_request
+		e.Kind = ErrThrottle
+	case 401, 402, 403, 406: // access_token_required, invalid_access_token, access_denied, access_token_compromised
+		e.Kind = ErrAuth
+	case 400, 404, 405: // bad_parameter, no_method, key_required
+		e.Kind = ErrBadParams
+	case 407, 500, 503: // write_failed, internal_error, temporarily_unavailable
+		e.Kind = ErrServer
+	default:
+		e.Kind = statusKind(status)
+	}
+	return e
+}
+
+// Returns the kind of error an HTTP status stands for, for responses without an error_id
+func statusKind(status int) ErrorKind {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrThrottle
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrAuth
+	case status >= 500:
+		return ErrServer
+	case status >= 400:
+		return ErrBadParams
+	}
+	return ErrUnknown
+}
+
+// Returns the kind of a request error, or ErrUnknown if err is not from the API or the client
+func KindOf(err error) ErrorKind {
+	switch e := err.(type) {
+	case *APIError:
+		return e.Kind
+	case *StopError:
+		return e.Kind
+	}
+	return ErrUnknown
+}
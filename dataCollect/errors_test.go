@@ -0,0 +1,67 @@
+package dataCollect
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		w      wrapper
+		kind   ErrorKind
+	}{
+		{"throttle violation", 400, wrapper{Error_id: 502, Quota_max: 300, Quota_remaining: 100}, ErrThrottle},
+		{"quota used up", 400, wrapper{Error_id: 502, Quota_max: 300, Quota_remaining: 0}, ErrQuota},
+		{"duplicate request", 409, wrapper{Error_id: 409}, ErrThrottle},
+		{"invalid access token", 401, wrapper{Error_id: 402}, ErrAuth},
+		{"access token compromised", 400, wrapper{Error_id: 406}, ErrAuth},
+		{"bad parameter", 400, wrapper{Error_id: 400}, ErrBadParams},
+		{"no method", 404, wrapper{Error_id: 404}, ErrBadParams},
+		{"write failed", 400, wrapper{Error_id: 407}, ErrServer},
+		{"temporarily unavailable", 503, wrapper{Error_id: 503}, ErrServer},
+		{"unknown error id", 500, wrapper{Error_id: 999}, ErrServer},
+
+		// Without an error_id the HTTP status is classified, so status 502 is not a throttle violation
+		{"bad gateway", 502, wrapper{}, ErrServer},
+		{"too many requests", 429, wrapper{}, ErrThrottle},
+		{"unauthorized", 401, wrapper{}, ErrAuth},
+		{"forbidden", 403, wrapper{}, ErrAuth},
+		{"not found", 404, wrapper{}, ErrBadParams},
+		{"conflict", 409, wrapper{}, ErrBadParams},
+		{"success", 200, wrapper{}, ErrUnknown},
+	}
+	for _, test := range tests {
+		e := newAPIError(test.status, test.w)
+		if e.Kind != test.kind {
+			t.Errorf("%v: got %v, want %v", test.name, e.Kind, test.kind)
+		}
+		if e.Status != test.status || e.ID != test.w.Error_id {
+			t.Errorf("%v: got status %v and id %v, want %v and %v", test.name, e.Status, e.ID, test.status, test.w.Error_id)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	withID := newAPIError(400, wrapper{Error_id: 400, Error_name: "bad_parameter", Error_message: "ids is invalid"})
+	if got, want := withID.Error(), "StackExchange bad parameters (400 bad_parameter): ids is invalid"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	withoutID := newAPIError(502, wrapper{})
+	if got, want := withoutID.Error(), "StackExchange server error (HTTP 502 Bad Gateway): "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	if kind := KindOf(&APIError{Kind: ErrAuth}); kind != ErrAuth {
+		t.Errorf("API error: got %v, want %v", kind, ErrAuth)
+	}
+	if kind := KindOf(&StopError{Kind: ErrQuota}); kind != ErrQuota {
+		t.Errorf("stop: got %v, want %v", kind, ErrQuota)
+	}
+	if kind := KindOf(errors.New("connection refused")); kind != ErrUnknown {
+		t.Errorf("other error: got %v, want %v", kind, ErrUnknown)
+	}
+}
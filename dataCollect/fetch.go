@@ -0,0 +1,290 @@
+/**
+ * Typed fetchers for the StackExchange API methods used by the app.
+ * Every fetcher shares the same pagination, filter, key and error handling through the Client.
+ */
+
+package dataCollect
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// Largest number of ids, or tags, the API accepts in one request
+const (
+	maxIDs  = 100
+	maxTags = 20
+)
+
+// Appends the items of page to collection, and copies the page's wrapper fields over collection's
+// collection and page must both be pointers to the same stackongo collection type, eg. *stackongo.Questions
+func appendPage(collection interface{}, page interface{}) {
+	c := reflect.ValueOf(collection).Elem()
+	p := reflect.ValueOf(page).Elem()
+	items := reflect.AppendSlice(c.FieldByName("Items"), p.FieldByName("Items"))
+	c.Set(p)
+	c.FieldByName("Items").Set(items)
+}
+
+// Collects every page of results from path into collection, starting at page
+// Returns the next page to collect if stopped before the last page, or 0 once complete.
+// Results collected before the request stopped are kept in collection, so no pages are lost.
+func fetchPages(client *Client, path string, params stackongo.Params, page int, collection interface{}) (int, error) {
+	for {
+		params.Page(page)
+		nextPage := reflect.New(reflect.TypeOf(collection).Elem()).Interface()
+		w, err := client.get(path, params, nextPage)
+		if err != nil {
+			return page, err
+		}
+		appendPage(collection, nextPage)
+
+		// If there are more pages of results, repeat with the next page
+		if !w.Has_more {
+			return 0, nil
+		}
+		page++
+	}
+}
+
+// Collects every page of results for ids into collection, batchSize ids at a time, asking for fields
+// format is the request path, with %v standing for the ids joined by semicolons
+func fetchByIDs(client *Client, format string, ids []string, batchSize int, appInfo AppDetails, params stackongo.Params, fields Fields, collection interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	params, err := addParams(client, appInfo, params, fields)
+	if err != nil {
+		return err
+	}
+	for startIndex := 0; startIndex < len(ids); startIndex += batchSize {
+		endIndex := startIndex + batchSize
+		if endIndex > len(ids) {
+			endIndex = len(ids)
+		}
+
+		path := fmt.Sprintf(format, strings.Join(ids[startIndex:endIndex], ";"))
+		if _, err := fetchPages(client, path, params, 1, collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A batch of ids that could not be fetched, and the error that stopped it
+type FailedBatch struct {
+	IDs []int
+	Err error
+}
+
+// Number of batches of ids requested at the same time
+// It is kept below the client's default QuotaReserve, so that the requests already sent
+// when the reserve is reached cannot use up the rest of the quota.
+const maxConcurrentBatches = 4
+
+// Collects every page of results for ids into collection, fetching up to maxConcurrentBatches batches of maxIDs ids at once
+// format is the request path, with %v standing for the ids joined by semicolons.
+// Results are appended to collection in the order of ids, including any pages collected from a batch before it failed.
+// Returns the batches that failed, and the error of the first of them.
+// Once the client stops, the batches not yet sent are failed with its *StopError instead of being sent.
+func fetchConcurrently(client *Client, format string, ids []int, params stackongo.Params, collection interface{}) ([]FailedBatch, error) {
+	var batches [][]int
+	for startIndex := 0; startIndex < len(ids); startIndex += maxIDs {
+		endIndex := startIndex + maxIDs
+		if endIndex > len(ids) {
+			endIndex = len(ids)
+		}
+		batches = append(batches, ids[startIndex:endIndex])
+	}
+
+	results := make([]interface{}, len(batches))
+	errs := make([]error, len(batches))
+	slots := make(chan bool, maxConcurrentBatches)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		slots <- true
+		if err := client.Stopped(); err != nil {
+			errs[i] = err
+			<-slots
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, batch []int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			results[i] = reflect.New(reflect.TypeOf(collection).Elem()).Interface()
+			path := fmt.Sprintf(format, strings.Join(idStrings(batch), ";"))
+			_, errs[i] = fetchPages(client, path, copyParams(params), 1, results[i])
+		}(i, batch)
+	}
+	wg.Wait()
+
+	var failed []FailedBatch
+	for i, batch := range batches {
+		if errs[i] != nil {
+			failed = append(failed, FailedBatch{IDs: batch, Err: errs[i]})
+		}
+		// A batch that failed before collecting anything has no wrapper fields worth copying
+		if results[i] != nil && (errs[i] == nil || reflect.ValueOf(results[i]).Elem().FieldByName("Items").Len() > 0) {
+			appendPage(collection, results[i])
+		}
+	}
+	if len(failed) > 0 {
+		return failed, failed[0].Err
+	}
+	return nil, nil
+}
+
+// Returns a copy of params, so that concurrent requests can each set their own page
+func copyParams(params stackongo.Params) stackongo.Params {
+	c := make(stackongo.Params)
+	for key, value := range params {
+		c[key] = value
+	}
+	return c
+}
+
+// Returns ids as strings to be joined into a request path
+func idStrings(ids []int) []string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return s
+}
+
+// Returns tags escaped to be joined into a request path, eg. "c#" becomes "c%23"
+func tagStrings(tags []string) []string {
+	s := make([]string, len(tags))
+	for i, tag := range tags {
+		s[i] = url.QueryEscape(tag)
+	}
+	return s
+}
+
+// Return answers with ids
+func GetAnswersByIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Answers, error) {
+	answers := new(stackongo.Answers)
+	err := fetchByIDs(client, "answers/%v", idStrings(ids), maxIDs, appInfo, params, AnswerFields, answers)
+	return answers, err
+}
+
+// Return comments on the posts, questions or answers, with ids
+func GetCommentsByPostIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Comments, error) {
+	comments := new(stackongo.Comments)
+	err := fetchByIDs(client, "posts/%v/comments", idStrings(ids), maxIDs, appInfo, params, CommentFields, comments)
+	return comments, err
+}
+
+// Return users with ids
+func GetUsersByIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Users, error) {
+	users := new(stackongo.Users)
+	err := fetchByIDs(client, "users/%v", idStrings(ids), maxIDs, appInfo, params, UserFields, users)
+	return users, err
+}
+
+// Return the details of tags, such as the number of questions with each tag
+func GetTagInfo(client *Client, tags []string, appInfo AppDetails, params stackongo.Params) (*stackongo.Tags, error) {
+	info := new(stackongo.Tags)
+	err := fetchByIDs(client, "tags/%v/info", tagStrings(tags), maxTags, appInfo, params, TagFields, info)
+	return info, err
+}
+
+// Return the synonyms of tags, which the site maps onto each of them
+func GetTagSynonyms(client *Client, tags []string, appInfo AppDetails, params stackongo.Params) (*stackongo.TagSynonyms, error) {
+	synonyms := new(stackongo.TagSynonyms)
+	err := fetchByIDs(client, "tags/%v/synonyms", tagStrings(tags), maxTags, appInfo, params, TagSynonymFields, synonyms)
+	return synonyms, err
+}
+
+// Return the revisions of the posts, questions or answers, with ids
+func GetRevisionsByPostIDs(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*stackongo.Revisions, error) {
+	revisions := new(stackongo.Revisions)
+	err := fetchByIDs(client, "posts/%v/revisions", idStrings(ids), maxIDs, appInfo, params, RevisionFields, revisions)
+	return revisions, err
+}
+
+// The account of a network user on one site, which stackongo does not parse
+type NetworkUser struct {
+	Account_id int
+	User_id    int
+	Site_name  string
+	Site_url   string
+}
+
+// A page of network users' accounts, with the same wrapper fields as the stackongo collections
+type NetworkUsers struct {
+	Items           []NetworkUser
+	Error_id        int
+	Error_name      string
+	Error_message   string
+	Backoff         int
+	Has_more        bool
+	Page            int
+	Page_size       int
+	Quota_max       int
+	Quota_remaining int
+	Total           int
+	Type            string
+}
+
+// Return the accounts on every site of the network users with account ids
+func GetAssociatedAccounts(client *Client, accountIDs []int, appInfo AppDetails, params stackongo.Params) (*NetworkUsers, error) {
+	accounts := new(NetworkUsers)
+	err := fetchByIDs(client, "users/%v/associated", idStrings(accountIDs), maxIDs, appInfo, params, AssociatedFields, accounts)
+	return accounts, err
+}
+
+// The question a duplicate was closed in favour of
+type OriginalQuestion struct {
+	Question_id        int
+	Title              string
+	Answer_count       int
+	Accepted_answer_id int
+}
+
+// Why and how a question was closed, which stackongo does not parse
+type ClosedDetails struct {
+	On_hold            bool
+	Reason             string
+	Description        string
+	Original_questions []OriginalQuestion
+}
+
+// A closed question with its closed_details
+type ClosedQuestion struct {
+	Question_id    int
+	Closed_reason  string
+	Closed_details ClosedDetails
+}
+
+// A page of closed questions, with the same wrapper fields as the stackongo collections
+type ClosedQuestions struct {
+	Items           []ClosedQuestion
+	Error_id        int
+	Error_name      string
+	Error_message   string
+	Backoff         int
+	Has_more        bool
+	Page            int
+	Page_size       int
+	Quota_max       int
+	Quota_remaining int
+	Total           int
+	Type            string
+}
+
+// Return the closed details of the questions with ids
+func GetClosedDetails(client *Client, ids []int, appInfo AppDetails, params stackongo.Params) (*ClosedQuestions, error) {
+	questions := new(ClosedQuestions)
+	err := fetchByIDs(client, "questions/%v", idStrings(ids), maxIDs, appInfo, params, ClosedDetailsFields, questions)
+	return questions, err
+}
@@ -0,0 +1,158 @@
+package dataCollect
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// A typed fetcher, run for the path it requests and returning the number of items it collected
+type fetcherCase struct {
+	name   string
+	path   string // Path requested for ids 1 and 2, or tags c# and go escaped
+	fields Fields
+	fetch  func(client *Client, appInfo AppDetails) (int, error)
+}
+
+var fetcherCases = []fetcherCase{
+	{"answers", "answers/1;2", AnswerFields, func(client *Client, appInfo AppDetails) (int, error) {
+		answers, err := GetAnswersByIDs(client, []int{1, 2}, appInfo, make(stackongo.Params))
+		return len(answers.Items), err
+	}},
+	{"comments", "posts/1;2/comments", CommentFields, func(client *Client, appInfo AppDetails) (int, error) {
+		comments, err := GetCommentsByPostIDs(client, []int{1, 2}, appInfo, make(stackongo.Params))
+		return len(comments.Items), err
+	}},
+	{"users", "users/1;2", UserFields, func(client *Client, appInfo AppDetails) (int, error) {
+		users, err := GetUsersByIDs(client, []int{1, 2}, appInfo, make(stackongo.Params))
+		return len(users.Items), err
+	}},
+	{"tag info", "tags/c%23;go/info", TagFields, func(client *Client, appInfo AppDetails) (int, error) {
+		info, err := GetTagInfo(client, []string{"c#", "go"}, appInfo, make(stackongo.Params))
+		return len(info.Items), err
+	}},
+	{"revisions", "posts/1;2/revisions", RevisionFields, func(client *Client, appInfo AppDetails) (int, error) {
+		revisions, err := GetRevisionsByPostIDs(client, []int{1, 2}, appInfo, make(stackongo.Params))
+		return len(revisions.Items), err
+	}},
+}
+
+// Returns the app details fetchers are called with, with an empty filter cache
+func testAppInfo() AppDetails {
+	return AppDetails{Key: "key", Filters: NewFilterCache()}
+}
+
+// Writes the fixture creating the filter for fields, named after the fields
+func writeFilterFixture(t *testing.T, dir string, fields Fields) {
+	params := map[string]string{"base": noneFilter, "include": fields.include(), "unsafe": "false", "page": "1"}
+	writeFixture(t, dir, "filters/create", params, 200, `{"items":[{"filter":"!`+fields.Name+`"}]}`)
+}
+
+// Returns the parameters a fetcher sends for page of results with the filter for fields
+func pageParams(fields Fields, page int) map[string]string {
+	return map[string]string{"filter": "!" + fields.Name, "site": DefaultSite, "page": strconv.Itoa(page)}
+}
+
+func TestFetchersCollectEveryPage(t *testing.T) {
+	for _, test := range fetcherCases {
+		client, dir, transport := replayClient(t)
+		writeFilterFixture(t, dir, test.fields)
+		writeFixture(t, dir, test.path, pageParams(test.fields, 1), 200, `{"items":[{},{}],"has_more":true}`)
+		writeFixture(t, dir, test.path, pageParams(test.fields, 2), 200, `{"items":[{}],"has_more":false}`)
+
+		if count, err := test.fetch(client, testAppInfo()); err != nil || count != 3 {
+			t.Errorf("%v: collected %v items (%v), want 3 from 2 pages", test.name, count, err)
+		}
+		if transport.Requests != 3 {
+			t.Errorf("%v: sent %v requests, want the filter and 2 pages", test.name, transport.Requests)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFetchersReturnAPIErrors(t *testing.T) {
+	for _, test := range fetcherCases {
+		client, dir, _ := replayClient(t)
+		writeFilterFixture(t, dir, test.fields)
+		writeFixture(t, dir, test.path, pageParams(test.fields, 1), 400,
+			`{"error_id":400,"error_name":"bad_parameter","error_message":"ids"}`)
+
+		if _, err := test.fetch(client, testAppInfo()); KindOf(err) != ErrBadParams {
+			t.Errorf("%v: got %v, want bad parameters", test.name, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFetchersReuseFilters(t *testing.T) {
+	client, dir, transport := replayClient(t)
+	defer os.RemoveAll(dir)
+	writeFilterFixture(t, dir, UserFields)
+	writeFixture(t, dir, "users/1;2", pageParams(UserFields, 1), 200, `{"items":[{}]}`)
+
+	appInfo := testAppInfo()
+	for i := 0; i < 2; i++ {
+		if _, err := GetUsersByIDs(client, []int{1, 2}, appInfo, make(stackongo.Params)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if transport.Requests != 3 {
+		t.Errorf("sent %v requests, want the filter created once", transport.Requests)
+	}
+}
+
+func TestFetchersBatchIDs(t *testing.T) {
+	client, dir, _ := replayClient(t)
+	defer os.RemoveAll(dir)
+	ids := make([]int, maxIDs+1)
+	first := make([]string, maxIDs)
+	for i := range ids {
+		ids[i] = i + 1
+		if i < maxIDs {
+			first[i] = strconv.Itoa(i + 1)
+		}
+	}
+	writeFilterFixture(t, dir, UserFields)
+	writeFixture(t, dir, "users/"+strings.Join(first, ";"), pageParams(UserFields, 1), 200, `{"items":[{},{}]}`)
+	writeFixture(t, dir, "users/"+strconv.Itoa(maxIDs+1), pageParams(UserFields, 1), 200, `{"items":[{}]}`)
+
+	users, err := GetUsersByIDs(client, ids, testAppInfo(), make(stackongo.Params))
+	if err != nil || len(users.Items) != 3 {
+		t.Errorf("collected %v users (%v), want 3 from 2 batches", len(users.Items), err)
+	}
+}
+
+func TestGetQuestionsByIDsKeepsOtherBatches(t *testing.T) {
+	client, dir, _ := replayClient(t)
+	defer os.RemoveAll(dir)
+	// Batches run concurrently, so the requests are not counted
+	client.SetTransport(&ReplayTransport{Dir: dir})
+
+	ids := make([]int, 2*maxIDs+1)
+	batches := make([][]string, 3)
+	for i := range ids {
+		ids[i] = i + 1
+		batches[i/maxIDs] = append(batches[i/maxIDs], strconv.Itoa(i+1))
+	}
+	writeFilterFixture(t, dir, QuestionFields)
+	writeFixture(t, dir, "questions/"+strings.Join(batches[0], ";"), pageParams(QuestionFields, 1), 200, `{"items":[{"question_id":1}]}`)
+	writeFixture(t, dir, "questions/"+strings.Join(batches[1], ";"), pageParams(QuestionFields, 1), 500,
+		`{"error_id":500,"error_name":"internal_error","error_message":"try again"}`)
+	writeFixture(t, dir, "questions/"+strings.Join(batches[2], ";"), pageParams(QuestionFields, 1), 200,
+		`{"items":[{"question_id":`+strconv.Itoa(2*maxIDs+1)+`}]}`)
+
+	questions, failed, err := GetQuestionsByIDs(client, ids, testAppInfo(), make(stackongo.Params))
+	if KindOf(err) != ErrServer {
+		t.Errorf("got %v, want the failed batch's server error", err)
+	}
+	if len(failed) != 1 || len(failed[0].IDs) != maxIDs || failed[0].IDs[0] != maxIDs+1 {
+		t.Errorf("failed batches %v, want only the second", failed)
+	}
+	// The batches either side of the failure are kept, in the order of the ids
+	if len(questions.Items) != 2 || questions.Items[0].Question_id != 1 || questions.Items[1].Question_id != 2*maxIDs+1 {
+		t.Errorf("collected %+v, want the questions of the first and last batches", questions.Items)
+	}
+}
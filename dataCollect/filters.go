@@ -0,0 +1,203 @@
+/**
+ * Creates filters, which choose the fields the StackExchange API returns.
+ * Each fetcher declares the fields it reads, and the filter for them is created once and cached.
+ */
+
+package dataCollect
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// A filter returned by filters/create
+type Filter struct {
+	Filter          string
+	Filter_type     string
+	Included_fields []string
+}
+
+// A page of filters
+type Filters struct {
+	Items           []Filter
+	Error_id        int
+	Error_name      string
+	Error_message   string
+	Backoff         int
+	Has_more        bool
+	Page            int
+	Page_size       int
+	Quota_max       int
+	Quota_remaining int
+	Total           int
+	Type            string
+}
+
+// Built in filter with no fields, which managed filters are built up from
+const noneFilter = "none"
+
+// Wrapper fields read from every response, see wrapper
+var wrapperFields = []string{
+	".backoff", ".error_id", ".error_message", ".error_name", ".has_more",
+	".items", ".page", ".quota_max", ".quota_remaining",
+}
+
+// The fields one kind of request asks for, on top of the wrapper fields
+type Fields struct {
+	Name    string   // Name the filter is cached under
+	Include []string // Fields in the API's type.field form, eg. "question.body"
+}
+
+// Returns every field the filter for f includes, joined to be sent and stored
+func (f Fields) include() string {
+	return strings.Join(append(append([]string{}, wrapperFields...), f.Include...), ";")
+}
+
+// Fields requested by each fetcher
+var (
+	// Questions to track, with what is stored and scored for them
+	QuestionFields = Fields{"questions", []string{
+		"question.question_id", "question.title", "question.body", "question.link", "question.creation_date", "question.tags",
+	}}
+	// The status and metrics of tracked questions
+	StatusFields = Fields{"question_status", []string{
+		"question.question_id", "question.closed_date", "question.closed_reason", "question.locked_date", "question.migrated_to",
+		"migration_info.question_id", "migration_info.on_date",
+		"question.score", "question.view_count", "question.answer_count", "question.favorite_count",
+	}}
+	// Why questions were closed, naming the question duplicates were closed in favour of
+	ClosedDetailsFields = Fields{"closed_details", []string{
+		"question.question_id", "question.closed_reason", "question.closed_details",
+		"closed_details.on_hold", "closed_details.reason", "closed_details.description", "closed_details.original_questions",
+		"original_question.question_id", "original_question.title", "original_question.answer_count", "original_question.accepted_answer_id",
+	}}
+	AnswerFields = Fields{"answers", []string{
+		"answer.answer_id", "answer.question_id", "answer.owner", "answer.creation_date", "answer.score", "answer.is_accepted",
+		"shallow_user.user_id", "shallow_user.display_name",
+	}}
+	CommentFields = Fields{"comments", []string{
+		"comment.comment_id", "comment.post_id", "comment.owner", "comment.creation_date", "comment.body",
+		"shallow_user.user_id", "shallow_user.display_name",
+	}}
+	UserFields = Fields{"users", []string{
+		"user.user_id", "user.display_name", "user.profile_image", "user.link",
+	}}
+	// The accounts a network user has on each site
+	AssociatedFields = Fields{"associated", []string{
+		"network_user.account_id", "network_user.user_id", "network_user.site_name", "network_user.site_url",
+	}}
+	TagFields = Fields{"tags", []string{
+		"tag.name", "tag.count", "tag.has_synonyms",
+	}}
+	TagSynonymFields = Fields{"tag_synonyms", []string{
+		"tag_synonym.from_tag", "tag_synonym.to_tag",
+	}}
+	RevisionFields = Fields{"revisions", []string{
+		"revision.post_id", "revision.revision_number", "revision.revision_type", "revision.creation_date",
+		"revision.title", "revision.body", "revision.tags", "revision.user",
+		"shallow_user.user_id", "shallow_user.display_name",
+	}}
+)
+
+// Returns the id of a filter with the fields of base plus include, eg. "question.closed_details"
+// Filters never expire, so the id can be stored and reused
+func CreateFilter(client *Client, appInfo AppDetails, base string, include []string) (string, error) {
+	params := make(stackongo.Params)
+	params.Add("key", appInfo.Key)
+	params.Add("base", base)
+	params.Add("include", strings.Join(include, ";"))
+	params.Add("unsafe", false)
+
+	filters := new(Filters)
+	if _, err := fetchPages(client, "filters/create", params, 1, filters); err != nil {
+		return "", err
+	}
+	if len(filters.Items) == 0 {
+		return "", fmt.Errorf("dataCollect/filters.go error: no filter created for %v", include)
+	}
+	return filters.Items[0].Filter, nil
+}
+
+// Stores the ids of created filters, so they are not created again by every instance
+type FilterStore interface {
+	// Returns the filter stored under name and the fields it was created with, or "" if there is none
+	LoadFilter(name string) (filter string, fields string, err error)
+	SaveFilter(name string, fields string, filter string) error
+}
+
+// A created filter and the fields it was created with
+type cachedFilter struct {
+	filter string
+	fields string
+}
+
+// Creates the filter for each set of fields the first time it is needed, and keeps its id
+// A filter is created again if the fields declared under its name change.
+type FilterCache struct {
+	lock    sync.Mutex
+	store   FilterStore
+	filters map[string]cachedFilter // By name
+}
+
+// Creates a cache that only keeps filters in memory until a store is set
+func NewFilterCache() *FilterCache {
+	return &FilterCache{filters: make(map[string]cachedFilter)}
+}
+
+// Sets the store filters are read from and saved to
+func (c *FilterCache) SetStore(store FilterStore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.store = store
+}
+
+// Returns the id of the filter for f, reading it from the store or creating it if needed
+// The cache is not locked while a filter is being created, so other filters can be read meanwhile.
+func (c *FilterCache) Filter(client *Client, appInfo AppDetails, f Fields) (string, error) {
+	fields := f.include()
+
+	c.lock.Lock()
+	if cached, ok := c.filters[f.Name]; ok && cached.fields == fields {
+		c.lock.Unlock()
+		return cached.filter, nil
+	}
+
+	store := c.store
+	if store != nil {
+		filter, storedFields, err := store.LoadFilter(f.Name)
+		if err != nil {
+			c.lock.Unlock()
+			return "", err
+		}
+		if filter != "" && storedFields == fields {
+			c.filters[f.Name] = cachedFilter{filter, fields}
+			c.lock.Unlock()
+			return filter, nil
+		}
+	}
+	c.lock.Unlock()
+
+	filter, err := CreateFilter(client, appInfo, noneFilter, strings.Split(fields, ";"))
+	if err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Another request may have created the filter meanwhile, the first one kept is used by all
+	if cached, ok := c.filters[f.Name]; ok && cached.fields == fields {
+		return cached.filter, nil
+	}
+	// The filter is kept even if it cannot be stored, so it is not created again by this instance
+	c.filters[f.Name] = cachedFilter{filter, fields}
+	if store != nil {
+		if err := store.SaveFilter(f.Name, fields, filter); err != nil {
+			log.Printf("Saving filter %v failed: %v", f.Name, err.Error())
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,40 @@
+package dataCollect
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// A filter store that has nothing stored and fails to save
+type failingStore struct {
+	Saves int
+}
+
+func (s *failingStore) LoadFilter(name string) (string, string, error) {
+	return "", "", nil
+}
+
+func (s *failingStore) SaveFilter(name string, fields string, filter string) error {
+	s.Saves++
+	return errors.New("db is down")
+}
+
+func TestFilterCacheKeepsUnsavedFilters(t *testing.T) {
+	client, dir, transport := replayClient(t)
+	defer os.RemoveAll(dir)
+	writeFilterFixture(t, dir, UserFields)
+
+	store := new(failingStore)
+	cache := NewFilterCache()
+	cache.SetStore(store)
+	for i := 0; i < 2; i++ {
+		filter, err := cache.Filter(client, testAppInfo(), UserFields)
+		if err != nil || filter != "!"+UserFields.Name {
+			t.Errorf("got filter %q (%v), want the created filter even though it was not saved", filter, err)
+		}
+	}
+	if transport.Requests != 1 || store.Saves != 1 {
+		t.Errorf("created the filter %v times and saved it %v times, want once each", transport.Requests, store.Saves)
+	}
+}
@@ -0,0 +1,180 @@
+/**
+ * Transports that record StackExchange API responses to fixture files and replay them,
+ * so the app can run offline and give the same results every time.
+ */
+
+package dataCollect
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Parameters left out of fixtures by default.
+// They hold secrets, and do not change what the API returns.
+var DefaultIgnoredParams = []string{"key", "access_token", "client_secret"}
+
+// Longest part of a request path kept in a fixture's file name
+const maxFixturePath = 100
+
+// A recorded response to one request
+type fixture struct {
+	Method string
+	Host   string
+	Path   string
+	Params map[string]string
+	Status int
+	Body   string
+}
+
+// Returns the parameters of a request, from the query and any form body, without the ignored ones.
+// The body of the request is read and replaced so that it can still be sent.
+func requestParams(req *http.Request, ignored []string) (map[string]string, error) {
+	values := req.URL.Query()
+	if req.Body != nil && req.Method == "POST" {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range form {
+			values[key] = value
+		}
+	}
+
+	params := make(map[string]string)
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+	for _, key := range ignored {
+		delete(params, key)
+	}
+	return params, nil
+}
+
+// Returns the name of the fixture file for a request.
+// Requests with the same method, host, path and parameters share a file.
+// The path is cut short in the name, as paths of a full batch of ids are too long for a file name.
+func fixtureName(method string, host string, path string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	id := method + " " + host + path
+	for _, key := range keys {
+		id += "&" + key + "=" + params[key]
+	}
+	sum := sha1.Sum([]byte(id))
+	name := strings.Trim(strings.Replace(path, "/", "_", -1), "_")
+	if len(name) > maxFixturePath {
+		name = name[:maxFixturePath]
+	}
+	return fmt.Sprintf("%s_%s.json", name, hex.EncodeToString(sum[:8]))
+}
+
+// RecordingTransport sends requests through Transport and saves each response to a fixture file in Dir
+type RecordingTransport struct {
+	Transport    http.RoundTripper
+	Dir          string
+	IgnoreParams []string // Parameters left out of fixtures, DefaultIgnoredParams if nil
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ignored := t.IgnoreParams
+	if ignored == nil {
+		ignored = DefaultIgnoredParams
+	}
+	params, err := requestParams(req, ignored)
+	if err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+
+	response, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	f := fixture{
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   req.URL.Path,
+		Params: params,
+		Status: response.StatusCode,
+		Body:   string(body),
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+	if err := os.MkdirAll(t.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+	name := filepath.Join(t.Dir, fixtureName(f.Method, f.Host, f.Path, f.Params))
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+	return response, nil
+}
+
+// ReplayTransport answers requests from the fixture files in Dir without sending anything.
+// A request with no matching fixture returns an error naming the file that was looked for.
+type ReplayTransport struct {
+	Dir          string
+	IgnoreParams []string // Parameters not matched against fixtures, DefaultIgnoredParams if nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ignored := t.IgnoreParams
+	if ignored == nil {
+		ignored = DefaultIgnoredParams
+	}
+	params, err := requestParams(req, ignored)
+	if err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v", err.Error())
+	}
+
+	name := filepath.Join(t.Dir, fixtureName(req.Method, req.URL.Host, req.URL.Path, params))
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: no fixture for %v %v: %v", req.Method, req.URL.Path, err.Error())
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("dataCollect/fixtures.go error: %v: %v", name, err.Error())
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json; charset=utf-8"}},
+		Body:          ioutil.NopCloser(strings.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package dataCollect
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Writes a fixture answering a get of path with params, as RecordingTransport would have saved it
+func writeFixture(t *testing.T, dir string, path string, params map[string]string, status int, body string) {
+	endpoint := setupEndpoint(path, params)
+	f := fixture{
+		Method: "GET",
+		Host:   endpoint.Host,
+		Path:   endpoint.Path,
+		Params: params,
+		Status: status,
+		Body:   body,
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fixtureName(f.Method, f.Host, f.Path, f.Params))
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Counts the requests that reach a transport
+type countingTransport struct {
+	Transport http.RoundTripper
+	Requests  int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Requests++
+	return t.Transport.RoundTrip(req)
+}
+
+// Returns a client replaying the fixtures in a new directory, the directory and the transport counting its requests
+func replayClient(t *testing.T) (*Client, string, *countingTransport) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &countingTransport{Transport: &ReplayTransport{Dir: dir}}
+	client := NewClient()
+	client.Interval = 0
+	client.SetTransport(transport)
+	return client, dir, transport
+}
+
+func TestRecordingTransportReplays(t *testing.T) {
+	live, liveDir, _ := replayClient(t)
+	defer os.RemoveAll(liveDir)
+	params := map[string]string{"site": "stackoverflow", "key": "secret"}
+	body := `{"items":[{"question_id":1}],"has_more":false,"quota_max":300,"quota_remaining":299}`
+	writeFixture(t, liveDir, "questions/1", map[string]string{"site": "stackoverflow"}, 200, body)
+
+	dir, err := ioutil.TempDir("", "recorded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	live.SetTransport(&RecordingTransport{Transport: live.transport, Dir: dir})
+	if _, err := live.get("questions/1", params, &struct{}{}); err != nil {
+		t.Fatalf("recording failed: %v", err)
+	}
+
+	// The recording leaves out the key, so it is replayed whatever key is used
+	replay := NewClient()
+	replay.SetTransport(&ReplayTransport{Dir: dir})
+	var questions struct{ Items []struct{ Question_id int } }
+	w, err := replay.get("questions/1", map[string]string{"site": "stackoverflow", "key": "other"}, &questions)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if len(questions.Items) != 1 || questions.Items[0].Question_id != 1 || w.Quota_remaining != 299 {
+		t.Errorf("replayed %+v with wrapper %+v, want question 1 and quota 299", questions, w)
+	}
+}
+
+func TestReplayTransportWithoutFixture(t *testing.T) {
+	client, dir, _ := replayClient(t)
+	defer os.RemoveAll(dir)
+	if _, err := client.get("questions/1", map[string]string{"site": "stackoverflow"}, &struct{}{}); err == nil {
+		t.Error("replayed a request with no fixture")
+	}
+}
+
+func TestFixtureNameLongPath(t *testing.T) {
+	ids := make([]string, maxIDs)
+	for i := range ids {
+		ids[i] = strconv.Itoa(1000000 + i)
+	}
+	path := "/2.2/questions/" + strings.Join(ids, ";")
+	name := fixtureName("GET", "api.stackexchange.com", path, nil)
+	if len(name) > 255 {
+		t.Errorf("fixture name is %v bytes, too long for a file", len(name))
+	}
+	// Paths differing only past the cut get their own files
+	if other := fixtureName("GET", "api.stackexchange.com", path+";1", nil); other == name {
+		t.Errorf("paths share the fixture %v", name)
+	}
+}
@@ -10,36 +10,48 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
-
-	"github.com/laktek/Stack-on-Go/stackongo"
 )
 
 var host string = "https://api.stackexchange.com" // API host site
 
-// Searches for questions on StackExchange API that match parameters
-func searchAdvanced(params map[string]string, transport http.RoundTripper) (*stackongo.Questions, error) {
-	request_path := "search/advanced"
-	output := new(stackongo.Questions)
-	if err := get(transport, request_path, params, output); err != nil {
-		return nil, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
-	}
-	return output, nil
+// Fields common to every response from the StackExchange API
+type wrapper struct {
+	Backoff         int
+	Error_id        int
+	Error_name      string
+	Error_message   string
+	Has_more        bool
+	Page            int
+	Quota_max       int
+	Quota_remaining int
 }
 
 // Sends a Get request through the transport and parses the response into collection
-func get(transport http.RoundTripper, section string, params map[string]string, collection interface{}) error {
+// Returns the wrapper fields of the response, which are filled in even if the API returned an error
+func get(transport http.RoundTripper, section string, params map[string]string, collection interface{}) (wrapper, error) {
 	client := &http.Client{Transport: transport}
 	response, err := client.Get(setupEndpoint(section, params).String())
 	if err != nil {
-		return fmt.Errorf("dataCollect/search.go error: %v", err.Error())
+		return wrapper{}, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
 	}
 
-	err = parseResponse(response, collection)
+	// Errors from parseResponse are returned as they are, so callers can tell an *APIError apart
+	return parseResponse(response, collection)
+}
+
+// Sends a Post request through the transport, with params as the form, and parses the response into collection
+// Used by the write API, which takes the access token and contents of a post in the form
+func post(transport http.RoundTripper, section string, params map[string]string, collection interface{}) (wrapper, error) {
+	client := &http.Client{Transport: transport}
+	form := url.Values{}
+	for key, value := range params {
+		form.Set(key, value)
+	}
+	response, err := client.PostForm(setupEndpoint(section, nil).String(), form)
 	if err != nil {
-		return fmt.Errorf("dataCollect/search.go error: %v", err.Error())
+		return wrapper{}, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
 	}
-
-	return nil
+	return parseResponse(response, collection)
 }
 
 // Return URL with params joined to path
@@ -58,20 +70,30 @@ func setupEndpoint(path string, params map[string]string) *url.URL {
 }
 
 // Parse response into result
-func parseResponse(response *http.Response, result interface{}) error {
+// The wrapper fields are returned separately so that callers can read the backoff and quota
+// Returns an *APIError if the API reported an error or the response has an error status
+func parseResponse(response *http.Response, result interface{}) (wrapper, error) {
 	defer response.Body.Close()
 
+	var w wrapper
 	bytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return fmt.Errorf("dataCollect/search.go error: %v", err.Error())
+		return w, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
 	}
 
-	if err := json.Unmarshal(bytes, result); err != nil {
-		return fmt.Errorf("dataCollect/search.go error: %v", err.Error())
+	if err := json.Unmarshal(bytes, &w); err != nil {
+		// Server errors may come back as a page that is not JSON
+		if response.StatusCode >= 500 {
+			return w, newAPIError(response.StatusCode, w)
+		}
+		return w, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
+	}
+	if w.Error_id != 0 || response.StatusCode >= 400 {
+		return w, newAPIError(response.StatusCode, w)
 	}
 
-	if response.StatusCode == 400 {
-		return fmt.Errorf("Bad request: %s", string(bytes))
+	if err := json.Unmarshal(bytes, result); err != nil {
+		return w, fmt.Errorf("dataCollect/search.go error: %v", err.Error())
 	}
-	return nil
+	return w, nil
 }
@@ -0,0 +1,53 @@
+/**
+ * Posts answers and comments through the StackExchange write API, as the user an access token belongs to.
+ * The token must have been granted the write_access scope.
+ */
+
+package dataCollect
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/laktek/Stack-on-Go/stackongo"
+)
+
+// Adds the parameters every write needs to params
+func writeParams(client *Client, appInfo AppDetails, params stackongo.Params, fields Fields, body string, accessToken string) (stackongo.Params, error) {
+	params.Add("access_token", accessToken)
+	params.Add("body", body)
+	params.Add("preview", false)
+	return addParams(client, appInfo, params, fields)
+}
+
+// Posts an answer to the question with id, returning the answer created
+func AddAnswer(client *Client, id int, body string, accessToken string, appInfo AppDetails, params stackongo.Params) (stackongo.Answer, error) {
+	params, err := writeParams(client, appInfo, params, AnswerFields, body, accessToken)
+	if err != nil {
+		return stackongo.Answer{}, err
+	}
+	answers := new(stackongo.Answers)
+	if _, err := client.post("questions/"+strconv.Itoa(id)+"/answers/add", params, answers); err != nil {
+		return stackongo.Answer{}, err
+	}
+	if len(answers.Items) == 0 {
+		return stackongo.Answer{}, fmt.Errorf("dataCollect/write.go error: no answer returned for question %v", id)
+	}
+	return answers.Items[0], nil
+}
+
+// Posts a comment on the post, a question or answer, with id, returning the comment created
+func AddComment(client *Client, id int, body string, accessToken string, appInfo AppDetails, params stackongo.Params) (stackongo.Comment, error) {
+	params, err := writeParams(client, appInfo, params, CommentFields, body, accessToken)
+	if err != nil {
+		return stackongo.Comment{}, err
+	}
+	comments := new(stackongo.Comments)
+	if _, err := client.post("posts/"+strconv.Itoa(id)+"/comments/add", params, comments); err != nil {
+		return stackongo.Comment{}, err
+	}
+	if len(comments.Items) == 0 {
+		return stackongo.Comment{}, fmt.Errorf("dataCollect/write.go error: no comment returned for post %v", id)
+	}
+	return comments.Items[0], nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -16,89 +17,187 @@ import (
 )
 
 // Returns questions and user data from the db filtered by parameters
-func readFromDb(ctx context.Context, params string) (webData, int64, error) {
+// Any ? placeholders in params are filled in with args
+// Questions hidden because of their status on StackExchange are left out
+func readFromDb(ctx context.Context, params string, args ...interface{}) (webData, int64, error) {
+	return readQuestionsFromDb(ctx, params, false, args...)
+}
+
+// Returns questions and user data from the db filtered by parameters
+// Any ? placeholders in params are filled in with args
+// Only hidden questions are returned if hidden is true, and only shown ones otherwise
+func readQuestionsFromDb(ctx context.Context, params string, hidden bool, args ...interface{}) (webData, int64, error) {
 	log.Infof(ctx, "Refreshing database read")
 
 	tempData := newWebData()
 	var (
 		url            string
 		title          string
+		site           string
 		id             int
 		state          string
 		body           string
 		creation_date  int64
 		last_edit_time sql.NullInt64
+		reason         sql.NullString
+		upstream_time  sql.NullInt64
+		status         string
+		status_reason  sql.NullString
+		duplicate_of   sql.NullInt64
+		relevance      sql.NullInt64
+		relevance_why  sql.NullString
+		posted_answer  sql.NullInt64
 		owner          sql.NullInt64
 		name           sql.NullString
 		pic            sql.NullString
 		link           sql.NullString
+		assignee       sql.NullInt64
+		assignee_name  sql.NullString
+		assigned_by    sql.NullInt64
+		assigned_at    sql.NullInt64
+		due_date       sql.NullInt64
 	)
 
 	//Select all questions in the database and read into a new data object
-	query := "SELECT * FROM questions LEFT JOIN user ON questions.user=user.id"
+	query := "SELECT questions.site, questions.question_id, questions.question_title, questions.question_url, questions.state, questions.body, " +
+		"questions.creation_date, questions.time_updated, questions.state_reason, questions.upstream_changed, questions.upstream_status, questions.upstream_reason, " +
+		"questions.duplicate_of, questions.relevance, questions.relevance_reasons, questions.answer_id, user.id, user.name, user.pic, user.link, " +
+		"assignment.assignee, assignee.name, assignment.assigned_by, assignment.assigned_at, assignment.due_date " +
+		"FROM questions LEFT JOIN user ON questions.user=user.id " +
+		"LEFT JOIN assignment ON questions.site=assignment.site AND questions.question_id=assignment.question_id " +
+		"LEFT JOIN user AS assignee ON assignment.assignee=assignee.id WHERE questions.hidden=" + strconv.FormatBool(hidden)
 	if params != "" {
-		query += " WHERE " + params
+		query += " AND (" + params + ")"
 	}
 	log.Infof(ctx, "query: %v", query)
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return tempData, 0, fmt.Errorf("query failed: %v", err.Error())
 	}
 
 	defer rows.Close()
-	//Iterate through each row and add to the correct cache
+	// Questions are read first, so their tags, answers, history and notes can be read for all of them at once
+	var (
+		questions []question
+		states    []string
+		owners    []int
+		keys      []backend.QuestionKey
+	)
 	for rows.Next() {
-		err := rows.Scan(&id, &title, &url, &state, &owner, &body, &creation_date, &last_edit_time, &owner, &name, &pic, &link)
+		err := rows.Scan(&site, &id, &title, &url, &state, &body, &creation_date, &last_edit_time, &reason, &upstream_time, &status, &status_reason, &duplicate_of, &relevance, &relevance_why, &posted_answer, &owner, &name, &pic, &link,
+			&assignee, &assignee_name, &assigned_by, &assigned_at, &due_date)
 		if err != nil {
 			log.Errorf(ctx, "query failed: %v", err)
 			continue
 		}
 
-		currentQ := stackongo.Question{
-			Question_id:   id,
-			Title:         title,
-			Link:          url,
-			Body:          body,
-			Creation_date: creation_date,
+		currentQ := question{
+			Question: stackongo.Question{
+				Question_id:   id,
+				Title:         title,
+				Link:          url,
+				Body:          body,
+				Creation_date: creation_date,
+			},
+			Site:         site,
+			Status:       status,
+			StatusReason: status_reason.String,
+			Hidden:       hidden,
+			DuplicateOf:  int(duplicate_of.Int64),
+			PostedAnswer: int(posted_answer.Int64),
 		}
 		if last_edit_time.Valid {
 			currentQ.Last_edit_date = last_edit_time.Int64
 		}
-
-		var tagToAdd string
-		//Get tags for that question, based on the ID
-		tagRows, err := db.Query("SELECT tag FROM question_tag WHERE question_id = ?", currentQ.Question_id)
-		if err != nil {
-			log.Errorf(ctx, "Tag retrieval failed: %v", err.Error())
-			continue
+		if relevance.Valid {
+			currentQ.Relevance = backend.StoredRelevance(int(relevance.Int64), relevance_why.String)
+		}
+		if upstream_time.Valid {
+			currentQ.UpstreamChanged = upstream_time.Int64
 		}
-		defer tagRows.Close()
-		for tagRows.Next() {
-			err := tagRows.Scan(&tagToAdd)
-			if err != nil {
-				log.Errorf(ctx, "Could not scan for tag: %v", err.Error())
-				continue
+		if assignee.Valid {
+			currentQ.Assignment = &backend.Assignment{
+				Assignee:     int(assignee.Int64),
+				AssigneeName: assignee_name.String,
+				AssignedBy:   int(assigned_by.Int64),
+				Assigned:     assigned_at.Int64,
+				Due:          due_date.Int64,
 			}
-			currentQ.Tags = append(currentQ.Tags, tagToAdd)
 		}
-		//Switch on the state as read from the database to ensure question is added to correct cace
-		tempData.Caches[state] = append(tempData.Caches[state], currentQ)
+		if reason.Valid && reason.String != "" {
+			tempData.Reasons[currentQ.Key()] = reason.String
+		}
 
+		ownerID := 0
 		if owner.Valid {
 			user := stackongo.User{
 				User_id:       int(owner.Int64),
 				Display_name:  name.String,
 				Profile_image: pic.String,
 			}
-			tempData.Qns[id] = user
+			tempData.Qns[currentQ.Key()] = user
 			if _, ok := tempData.Users[user.User_id]; !ok {
 				tempData.Users[user.User_id] = newUser(user)
 			}
-			tempData.Users[user.User_id].Caches[state] = append(tempData.Users[user.User_id].Caches[state], currentQ)
+			ownerID = user.User_id
 		}
+
+		questions = append(questions, currentQ)
+		states = append(states, state)
+		owners = append(owners, ownerID)
+		keys = append(keys, backend.QuestionKey{Site: site, ID: id})
 	}
 
+	tags, err := backend.ReadTagsOf(db, keys)
+	if err != nil {
+		log.Errorf(ctx, "Tag retrieval failed: %v", err.Error())
+	}
+	answers, err := backend.ReadAnswersOf(db, keys)
+	if err != nil {
+		log.Errorf(ctx, "Answer retrieval failed: %v", err.Error())
+	}
+	history, err := backend.ReadHistoryOf(db, keys)
+	if err != nil {
+		log.Errorf(ctx, "History retrieval failed: %v", err.Error())
+	}
+	notes, err := backend.ReadNotesOf(db, keys)
+	if err != nil {
+		log.Errorf(ctx, "Note retrieval failed: %v", err.Error())
+	}
+
+	// Duplicates and their states, to be grouped under their canonical questions once every question is read
+	duplicates := []question{}
+	duplicateStates := []string{}
+	//Iterate through each question and add to the correct cache
+	for i, currentQ := range questions {
+		currentQ.Tags = tags[keys[i]]
+		currentQ.Answers = answers[keys[i]]
+		currentQ.History = history[keys[i]]
+		currentQ.Notes = notes[keys[i]]
+
+		//Switch on the state as read from the database to ensure question is added to correct cace
+		state := states[i]
+		// Questions left in a state removed from the workflow are shown together rather than lost
+		cache := state
+		if !backend.CurrentWorkflow().Valid(state) {
+			log.Warningf(ctx, "Question %v is in state %q, which is not in the workflow", currentQ.Key(), state)
+			cache = unknownState
+		}
+		if currentQ.DuplicateOf != 0 {
+			duplicates = append(duplicates, currentQ)
+			duplicateStates = append(duplicateStates, cache)
+		} else {
+			tempData.Caches[cache] = append(tempData.Caches[cache], currentQ)
+		}
+		if owners[i] != 0 {
+			tempData.Users[owners[i]].Caches[state] = append(tempData.Users[owners[i]].Caches[state], currentQ)
+		}
+	}
+
+	groupDuplicates(tempData.Caches, duplicates, duplicateStates)
+	tempData.Team = readTeamFromDb(ctx)
+
 	for cacheType, _ := range tempData.Caches {
 		sort.Sort(byCreationDate(tempData.Caches[cacheType]))
 	}
@@ -106,16 +205,44 @@ func readFromDb(ctx context.Context, params string) (webData, int64, error) {
 	return tempData, time.Now().Unix(), nil
 }
 
+// Adds each duplicate to the question it duplicates, so the team sees them together and answers once
+// Duplicates whose canonical question was not read are added to caches under their own state
+func groupDuplicates(caches map[string][]question, duplicates []question, states []string) {
+	for i, duplicate := range duplicates {
+		canonical := question{Question: stackongo.Question{Question_id: duplicate.DuplicateOf}, Site: duplicate.Site}
+		grouped := false
+		for state, questions := range caches {
+			for j := range questions {
+				if questions[j].Key() == canonical.Key() {
+					caches[state][j].Duplicates = append(caches[state][j].Duplicates, duplicate)
+					grouped = true
+					break
+				}
+			}
+			if grouped {
+				break
+			}
+		}
+		if !grouped {
+			caches[states[i]] = append(caches[states[i]], duplicate)
+		}
+	}
+}
+
 //Function called when the /viewTags request is made
-//Retrieves all distinct tags and the number of questions saved in the db with that tag
+//Retrieves all tag families and the number of questions saved in the db with a tag in each family
+//Questions with several tags in the same family are counted once
 func readTagsFromDb(ctx context.Context) []tagData {
 	var tempData []tagData
 	var (
-		tag   sql.NullString
-		count sql.NullInt64
+		tag     sql.NullString
+		count   sql.NullInt64
+		members sql.NullString
 	)
 
-	rows, err := db.Query("SELECT tag, COUNT(tag) FROM question_tag GROUP BY tag")
+	rows, err := db.Query("SELECT COALESCE(tag_family.family, question_tag.tag) AS family, " +
+		"COUNT(DISTINCT question_tag.site, question_tag.question_id), GROUP_CONCAT(DISTINCT question_tag.tag SEPARATOR ';') " +
+		"FROM question_tag LEFT JOIN tag_family ON question_tag.site=tag_family.site AND question_tag.tag=tag_family.tag GROUP BY family")
 	if err != nil {
 		log.Warningf(ctx, "Tag query failed: %v", err.Error())
 		return tempData
@@ -123,12 +250,17 @@ func readTagsFromDb(ctx context.Context) []tagData {
 
 	defer rows.Close()
 	for rows.Next() {
-		err := rows.Scan(&tag, &count)
+		err := rows.Scan(&tag, &count, &members)
 		if err != nil {
 			log.Warningf(ctx, "Tag Scan failed: %v", err.Error())
 			continue
 		}
-		currentTag := tagData{tag.String, int(count.Int64)}
+		currentTag := tagData{tag.String, int(count.Int64), []string{}}
+		for _, member := range strings.Split(members.String, ";") {
+			if member != "" && member != tag.String {
+				currentTag.Members = append(currentTag.Members, member)
+			}
+		}
 		tempData = append(tempData, currentTag)
 	}
 
@@ -148,7 +280,7 @@ func readUsersFromDb(ctx context.Context, params string) map[int]userData {
 		link sql.NullString
 	)
 
-	query := "SELECT * FROM user"
+	query := "SELECT id, name, pic, link FROM user"
 	if params != "" {
 		query += " WHERE " + params
 	}
@@ -182,6 +314,30 @@ func readUsersFromDb(ctx context.Context, params string) map[int]userData {
 	return tempData
 }
 
+// Returns the team members who have logged in, sorted by name, to assign questions to
+func readTeamFromDb(ctx context.Context) []stackongo.User {
+	var team []stackongo.User
+	inTeam, args := backend.TeamIn("id")
+	rows, err := db.Query("SELECT id, name FROM user WHERE "+inTeam+" ORDER BY name", args...)
+	if err != nil {
+		log.Warningf(ctx, "Team query failed: %v", err.Error())
+		return team
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id   int
+			name sql.NullString
+		)
+		if err := rows.Scan(&id, &name); err != nil {
+			log.Warningf(ctx, "Team scan failed: %v", err.Error())
+			continue
+		}
+		team = append(team, stackongo.User{User_id: id, Display_name: name.String})
+	}
+	return team
+}
+
 /* Function to check if the DB has been updated since we last queried it
 Returns true if our cache needs to be refreshed
 False if is all g */
@@ -196,32 +352,30 @@ func checkDBUpdateTime(ctx context.Context, tableName string, lastUpdate int64)
 	return last_updated > lastUpdate
 }
 
-func readUserFromDb(ctx context.Context, username string) stackongo.User {
+// Returns the user with id from the database, or an empty user if they are not in it
+func readUserFromDb(ctx context.Context, id int) stackongo.User {
 	//Reading from database
 	log.Infof(ctx, "Refreshing database read")
 	var (
-		id    sql.NullInt64
 		name  sql.NullString
 		image sql.NullString
 	)
-	//Select all questions in the database and read into a new data object
-	err := db.QueryRow("SELECT id, name, pic FROM user WHERE name='"+username+"'").Scan(&id, &name, &image)
+	//Select the user with the id
+	err := db.QueryRow("SELECT name, pic FROM user WHERE id=?", id).Scan(&name, &image)
 	if err != nil {
 		log.Errorf(ctx, "User Scan failed: %v", err.Error())
 		return stackongo.User{}
 	}
 
-	if id.Valid {
-		return stackongo.User{
-			User_id:       int(id.Int64),
-			Display_name:  name.String,
-			Profile_image: image.String,
-		}
+	return stackongo.User{
+		User_id:       id,
+		Display_name:  name.String,
+		Profile_image: image.String,
 	}
-	return stackongo.User{}
 }
 
 // Write user data into the database
+// User ids belong to the site users log in through, and their ids on other sites are kept in user_account
 func addUserToDB(ctx context.Context, newUser stackongo.User) {
 
 	stmts, err := db.Prepare("INSERT IGNORE INTO user (id, name, pic) VALUES (?, ?, ?)")
@@ -264,11 +418,13 @@ func updatingCache_User(ctx context.Context, r *http.Request, user stackongo.Use
 	r.ParseForm()
 
 	cache := r.PostFormValue("cache")
+	site := backend.SiteName(r.PostFormValue("site"))
 	qnID, _ := strconv.Atoi(r.PostFormValue("question_id"))
 	form_input := r.PostFormValue("state")
+	comment := strings.TrimSpace(r.PostFormValue("comment"))
 
 	// Update the database
-	if err := backend.UpdateQns(db, ctx, qnID, cache, form_input, user.User_id, mostRecentUpdate); err != nil {
+	if err := backend.UpdateQns(db, ctx, site, qnID, cache, form_input, user.User_id, mostRecentUpdate, comment); err != nil {
 		return int64(0), err
 	}
 	return updateTime, nil
@@ -1,10 +1,9 @@
 /* ====================== MAINPAGE  ===================== */
 /* THIS IS THE JAVASCRIPT FOR THE MAIN PAGE */
 
-// Logs out of current user
+// Logs out of current user, ending their session
 function logout() {
-  document.cookie = "user_name=Guest";
-  window.location.reload();
+  window.location = '/logout';
 }
 
 // Returns true if the user is not valid
@@ -160,37 +159,32 @@ function clearTextPreserveChildren(element) {
 
 // When a user enters a URL or ID into the search box on the find question page
 // Checks that the input from the form is either a URL or an id number
-// Parses the incoming string to isolate the question ID from the URL
+// Parses the incoming string to isolate the site and question ID from the URL
+// An id number is looked up on the site entered beside it, or StackOverflow if none is given
 // If its not valid, it yells at you, and instructs how to format 
 function pullQuestionFromStackOverflow() {
   var alert = $('#new-question-alert');
   alert.hide();
   var query = $('input#searchTerm').val();
+  var site = $('input#siteTerm').val();
 
   if(!$.isNumeric(query)) {
-    var check1 = canSplit(query, "http://stackoverflow.com/questions/");
+    // Question URLs look like http://<site>/questions/<id>/<title>
+    var match = /^https?:\/\/([^\/]+)\/(?:questions|q)\/(\d+)/.exec(query);
 
-    if(check1) {
-      var queryA = query.split("http://stackoverflow.com/questions/");
-      var check2 = canSplit(query.split("http://stackoverflow.com/questions/")[1], "/");
-
-      if(check2) {
-        queryB = query.split("http://stackoverflow.com/questions/")[1].split("/")[0];
-
-        if($.isNumeric(queryB)) {
-          pullNewQn(queryB);   
-        }
-      } else {
-        removeAlertClass(alert);
-        alert.html('Not a valid search term.<br>Please enter a valid StackOverflow URL' +
-          'StackOverflow question ID. <br><br> Eg,'+
-          ' http://stackoverflow.com/questions/123456/example-question-title or 123456');
-        alert.addClass('alert-warning');
-        alert.show();
-      }
+    if(match) {
+      pullNewQn(match[2], match[1]);
+    } else {
+      removeAlertClass(alert);
+      alert.html('Not a valid search term.<br>Please enter a valid StackExchange URL or ' +
+        'question ID. <br><br> Eg,'+
+        ' http://stackoverflow.com/questions/123456/example-question-title,' +
+        ' http://gis.stackexchange.com/questions/123456/example-question-title or 123456');
+      alert.addClass('alert-warning');
+      alert.show();
     }
   } else {
-    pullNewQn(query);
+    pullNewQn(query, site);
   }
 }
 
@@ -211,12 +205,12 @@ function removeAlertClass(alert) {
   }
 }
 
-// Formats a post request to the server to pull the question from Stack Overflow
+// Formats a post request to the server to pull the question from the StackExchange site
 // Once a response is received, the relevant elements are cleared to be refilled with 
 // fresh data. If the response is empty or undefined, an error is displayed to the user.
 // Otherwise, a display function is called to read the data into the page.
-function pullNewQn(query) {
-  $.post('/pullNewQn?id='+query, function( data ) {
+function pullNewQn(query, site) {
+  $.post('/pullNewQn?id='+query+'&site='+encodeURIComponent(site || ''), function( data ) {
     var table = $('table');
     var alert = $('#new-question-alert');
     clearTextPreserveChildren(table);
@@ -234,15 +228,36 @@ function pullNewQn(query) {
 }
 
 
+// Returns the state of the first tab, or of the first tab of a state questions are owned in if owned is set
+function defaultTab(owned) {
+  var tab = $('#tabs li' + (owned ? '.owned' : '') + ' a').first();
+  if (tab.length == 0) {
+    tab = $('#tabs li a').first();
+  }
+  return tab.length == 0 ? '' : tab.attr('href').substring(1);
+}
+
+// Returns the state with name from the workflow the page was served with, or undefined
+function workflowState(name) {
+  for (var i = 0; i < workflow.States.length; i++) {
+    if (workflow.States[i].Name == name) {
+      return workflow.States[i];
+    }
+  }
+  return undefined;
+}
+
 // Parses a JSON object and displays it in the table for viewing
 // First, it determines if the data is a new or existing question
 // new/existing require different button functionality and different naming
 // A previously existing question has an extra field called Message, which 
 // basically says that this is an existing question.
 // This means this question must have some sort of state assigned to it
-// It needs to display this state, and the appropriate buttons for usage
-// The select menu is only shown on questions marked as unanswered, pending or
-// updating. The answered state only has a single button marked Reopen.
+// It needs to display this state, and the buttons the workflow has for that state:
+// its action as the button and its other transitions in the select menu, which is
+// hidden if there are none.
+// A new question can be added in any state but review, the button adding it in the
+// state the initial state's action moves questions to.
 // 
 // The JSON is saved in local storage, so that if that question is submitted 
 // into the database the question information is still available
@@ -260,22 +275,24 @@ function displayNewQuestion(data) {
   btn.off('click');
   cancel.addClass('hidden');
   menu.append($("<option disabled selected></option>").text('Choose an option...'))
-  btn.attr('name', 'unanswered_'+ question.Question_id);
+  btn.attr('name', workflow.Initial + '_' + question.Site + '_' + question.Question_id);
   btn.click().addClass('clicked');
-  btn.attr('value', 'Pending');
   clearTextPreserveChildren($('.questionOwner'));
 
   if(type == undefined) {
+    var initial = workflowState(workflow.Initial);
     menu.removeClass('hidden');
     cancel.removeClass('hidden');
-    options = {
-      "unanswered":"Unanswered", 
-      "answered":"Answered",
-      "updating":"Updating"
-    };
+    $.each(workflow.States, function(i, state) {
+      if (state.Name != workflow.Review && state.Name != initial.Action) {
+        options[state.Name] = state.Label;
+      }
+    });
+    btn.attr('value', initial.ActionLabel).data('state', initial.Action);
+    btn.toggleClass('hidden', !initial.Action);
     btn.off('click');
     btn.on('click', function() {
-      addQuestionToStackTracker(data, btn.attr('value').toLowerCase());
+      addQuestionToStackTracker(data, btn.data('state'));
     });
     cancel.on('click', function() {
       clearNewQuestionTable();
@@ -285,54 +302,43 @@ function displayNewQuestion(data) {
     });
   } else { 
     type = question.State;
-    if(type == "pending") {
-      menu.removeClass('hidden');
-      options = {
-        "updating":"Updating", 
-        "answered":""
-      }
-      btn.attr('value', 'Answered');
-    } else if(type == "updating") {
-      menu.removeClass('hidden');
-      options = {
-        "pending":"Pending",
-        "answered":""
-      };
-      btn.attr('value', 'Answered');
-    } else if(type == "answered") {
-      menu.addClass('hidden');
-      options = {
-        "pending":""
+    var state = workflowState(type) || {Transitions: []};
+    var others = 0;
+    $.each(state.Transitions, function(i, name) {
+      if (name != state.Action) {
+        options[name] = workflowState(name).Label;
+        others++;
       }
-      btn.attr('value', 'Reopen');
-    } else {
-      menu.removeClass('hidden');
-      options = { 
-        "answered":"Answered",
-        "updating":"Updating",
-        "pending":""
-      };
-      btn.attr('value', 'Pending');
+    });
+    // The action is kept as the last, hidden, option for the button to select
+    if (state.Action) {
+      options[state.Action] = "";
     }
+    menu.toggleClass('hidden', others == 0);
+    btn.attr('value', state.ActionLabel).data('state', state.Action);
+    btn.toggleClass('hidden', !state.Action);
 
-    btn.attr('name', type + '_' + question.Question_id);
-    menu.attr('name', type + '_' + question.Question_id);
+    btn.attr('name', type + '_' + question.Site + '_' + question.Question_id);
+    menu.attr('name', type + '_' + question.Site + '_' + question.Question_id);
     btn.off('click');
     btn.on('click', function() { 
-      submitForm(localStorage["currentUser"], btn.prop('value').toLowerCase(), 
+      submitForm(localStorage["currentUser"], btn.data('state'), 
       localStorage["lastUpdateTime"]);
     });
     menu.off('change');
     menu.on('change', function() {
-      submitForm(localStorage["currentUser"], menu.prop('value').toLowerCase(),
+      submitForm(localStorage["currentUser"], menu.prop('value'),
         localStorage["lastUpdateTime"])
     });
   }
 
   $.each(options, function(value, key) {
-      menu.append($("<option></option>").attr("value", value).text(key));
+      var option = $("<option></option>").attr("value", value).text(key);
+      if (key == "") { // The action's option is only selected by the button
+        option.hide();
+      }
+      menu.append(option);
   });
-  menu.children().last().hide();
   if(question.Message != undefined && question.Message != "") {
     alert.hide();
     removeAlertClass(alert);
@@ -344,7 +350,7 @@ function displayNewQuestion(data) {
     alert.hide();
     removeAlertClass(alert);
     alert.html(
-      'New question with ID: '+question.Question_id+' found!');
+      'New question with ID: '+question.Question_id+' found on '+question.Site+'!');
     alert.addClass('alert-info');
     alert.show();
   }
@@ -356,11 +362,58 @@ function displayNewQuestion(data) {
       +'"><li class="tag">'+item+'</li></a>');
   });
 
+  $('ul.answers').empty();
+  $.each(question.Answers || [], function(i, answer) {
+    var item = $('<li class="answer"></li>');
+    if(answer.Is_accepted) {
+      item.addClass('accepted');
+    }
+    item.append($('<a target="_blank"></a>').attr('href', question.Link + '#' + answer.Answer_id)
+      .text(answer.Is_accepted ? 'Accepted answer' : 'Answer'));
+    item.append(document.createTextNode(' by ' + answer.Owner.Display_name + ', score ' + answer.Score));
+    $('ul.answers').append(item);
+  });
+
   if(question.UserDisplayName != undefined && question.UserDisplayName != "") {
     $('.questionOwner').html('Question marked as '+question.State
       +' by <a href=\"/user?id='+question.UserID+'\">'+question.UserDisplayName
       +'</a> on '+ question.Time);
   }
+  if(question.UpstreamChanged != undefined && question.UpstreamChanged != "") {
+    $('.questionOwner').append($('<br>'))
+      .append(document.createTextNode('Edited on StackOverflow, updated here on ' + question.UpstreamChanged));
+  }
+  if(question.StateReason != undefined && question.StateReason != "") {
+    $('.questionOwner').append($('<br>'))
+      .append(document.createTextNode('Moved automatically. ' + question.StateReason));
+  }
+
+  $('ul.transitions').empty();
+  $.each(question.History || [], function(i, transition) {
+    var text = new Date(transition.Time * 1000).toLocaleString() + ': ' +
+      (transition.From || 'new') + ' to ' + transition.To;
+    if(transition.RuleID) {
+      text += ' automatically';
+    } else if(transition.UserName) {
+      text += ' by ' + transition.UserName;
+    }
+    if(transition.Reason) {
+      text += ' (' + transition.Reason + ')';
+    }
+    if(transition.Comment) {
+      text += ' - ' + transition.Comment;
+    }
+    $('ul.transitions').append($('<li class="transition"></li>').text(text));
+  });
+
+  // Notes are only sent to team members, already rendered and sanitized
+  $('div.notes').empty();
+  $.each(question.Notes || [], function(i, note) {
+    var item = $('<div class="note"></div>');
+    item.append($('<p class="questionOwner"></p>').text(note.UserName + ' on ' + new Date(note.Created * 1000).toLocaleString()));
+    item.append(note.HTML);
+    $('div.notes').append(item);
+  });
   $('table').removeClass('hidden');
 }
 
@@ -414,13 +467,14 @@ function addQuestionToStackTracker(newQuestion, newState) {
       url: "/addNewQuestion",
       processData: false,
       contentType: 'application/json',
+      headers: {'X-CSRF-Token': csrfToken()},
       data: JSON.stringify({"Question":newQuestion, "State":newState}),
       success: function( data ) {
         var alert = $('#new-question-alert');
         removeAlertClass(alert);
         alert.addClass('alert-success');
         var alertString
-         if(newState == "unanswered") {
+         if(!workflowState(newState).Owned) {
             alertString = "Question successfully added to list of"+newState+" questions!"
           } else {
             alertString = "Question successfully added to "+localStorage["currentUser"]+
@@ -433,25 +487,55 @@ function addQuestionToStackTracker(newQuestion, newState) {
   }
 }
 
+// Returns the token the server expects on posts from the current session
+function csrfToken() {
+  return $('meta[name="csrf-token"]').attr('content') || '';
+}
+
+// Returns the text telling the user a question was moved by someone else first, from the conflict sent back
+function conflictText(conflict) {
+  if (conflict.State == '') {
+    return 'This question is no longer being tracked.';
+  }
+  var text = 'This question has already been marked as ' + conflict.State;
+  if (conflict.UserName) {
+    text += ' by ' + conflict.UserName;
+  }
+  return text + '.\nYour change was not saved.';
+}
+
 // Submitting the Post form as a request
 $(function() {
   $('#stateForm').submit(function() {
     // Set cookie for webui to check.
     document.cookie = 'submitting=true';
 
-    // Get the current state and the question id of the changed question.
+    // Get the current state, the site and the question id of the changed question.
     var qnElems = $('.new_state_menu option[value!="no_change"]:selected').parent().attr('name').split("_");
     var cache = qnElems[0];
-    var qnID = qnElems[1];
+    var site = qnElems[1];
+    var qnID = qnElems[2];
 
     // Get the new state of the changed question.
     var newState = $('.new_state_menu option[value!="no_change"]:selected').val();
 
-    // Post the state with the current state and id of the question.
+    // Get the comment left next to the changed question's menu, if any.
+    var comment = $('.new_state_menu option[value!="no_change"]:selected').closest('td').find('.state_comment').val() || '';
+
+    // Post the state with the current state, site and id of the question, and the comment for its history.
     // When done posting, redirect back to the original page.
-    $.post( '/', {'cache': cache, 'question_id': qnID, 'state': newState})
+    $.post( '/', {'cache': cache, 'site': site, 'question_id': qnID, 'state': newState, 'comment': comment, 'csrf': csrfToken()})
       .done(function( data ) {
         window.location = window.location.href.split('#')[0];
+      })
+      .fail(function( xhr ) {
+        if (xhr.status == 409) { // Someone else moved the question first
+          alert(conflictText(JSON.parse(xhr.responseText)));
+        } else {
+          alert('The question could not be updated:\n' + xhr.responseText);
+        }
+        // Reload to show the question as it is now.
+        window.location = window.location.href.split('#')[0];
       });
     return false;
   });
@@ -459,9 +543,6 @@ $(function() {
 
 //-------- SETTING PREP WHEN DOCUMENT LOADS -------//
 $(document).ready(function() {
-  // Set neccessary cookies for user verification.
-  setCookies();
-
   // Remove code query from url.
   // If code query not in url, url remains the same.
   var url = removeQuery('code', window.location.href);
@@ -474,8 +555,8 @@ $(document).ready(function() {
   // Add tab query to pages with questions.
   if (window.location.search.indexOf('tab') == -1 &&
     subpage.indexOf('viewTags') == -1 && subpage.indexOf('viewUsers') == -1 &&
-    subpage.indexOf('addQuestion') == -1 && subpage.indexOf('user') == -1) {
-      var addedPath = subpage + addQuery('tab', 'unanswered', window.location.search);
+    subpage.indexOf('viewWatches') == -1 && subpage.indexOf('addQuestion') == -1 && subpage.indexOf('user') == -1) {
+      var addedPath = subpage + addQuery('tab', defaultTab(false), window.location.search);
       window.history.replaceState('', document.title, addedPath);
   } else if (window.location.search.indexOf('page') == -1 && (subpage.indexOf('viewTags') != -1 ||
     subpage.indexOf('viewUsers') != -1)) { // Add page number query to viewing pages.
@@ -483,19 +564,13 @@ $(document).ready(function() {
       window.history.replaceState('', document.title, addedPath);
   } else if(window.location.search.indexOf('tab') == -1 &&
     subpage.indexOf('user') != -1) {
-      var addedPath = subpage + addQuery('tab', 'pending', window.location.search);
+      var addedPath = subpage + addQuery('tab', defaultTab(true), window.location.search);
       window.history.replaceState('', document.title, addedPath);
   }
 
   setActiveTab(window.location.href);
 });
 
-//-------- COOKIES --------//
-function setCookies() {
-  // Set user cookie
-  document.cookie = 'user_name=' + localStorage['currentUser'];
-}
-
 //-------- Removing queries ---------//
 function clearEmptyQueries() {
   // Get the host url
@@ -561,3 +636,43 @@ function removeQuery(key, sourceURL) {
   }
   return newURL;
 }
+
+// Draws the snapshots of a question or tag on the metrics page
+// Views are charted apart from the other metrics, as they are usually far larger.
+// Team answers are marked by annotation lines on the counts chart.
+function drawMetrics(snapshots, teamAnswers) {
+  if (snapshots.length == 0) {
+    $('#noSnapshots').show();
+    return;
+  }
+
+  var counts = new google.visualization.DataTable();
+  counts.addColumn('datetime', 'Synced');
+  counts.addColumn({type: 'string', role: 'annotation'});
+  counts.addColumn('number', 'Score');
+  counts.addColumn('number', 'Answers');
+  counts.addColumn('number', 'Favourites');
+
+  var views = new google.visualization.DataTable();
+  views.addColumn('datetime', 'Synced');
+  views.addColumn('number', 'Views');
+
+  for (var i = 0; i < snapshots.length; i++) {
+    var time = new Date(snapshots[i].Time * 1000);
+    counts.addRow([time, null, snapshots[i].Score, snapshots[i].Answers, snapshots[i].Favorites]);
+    views.addRow([time, snapshots[i].Views]);
+  }
+  for (var i = 0; i < teamAnswers.length; i++) {
+    counts.addRow([new Date(teamAnswers[i] * 1000), 'Team answer', null, null, null]);
+  }
+  counts.sort([{column: 0}]);
+
+  var options = {
+    height: 300,
+    interpolateNulls: true,
+    annotations: {style: 'line'},
+    legend: {position: 'bottom'}
+  };
+  new google.visualization.LineChart(document.getElementById('countChart')).draw(counts, options);
+  new google.visualization.LineChart(document.getElementById('viewChart')).draw(views, options);
+}
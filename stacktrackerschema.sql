@@ -23,9 +23,10 @@ DROP TABLE IF EXISTS `question_tag`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE `question_tag` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
   `question_id` int(11) NOT NULL DEFAULT '0',
   `tag` varchar(255) NOT NULL DEFAULT '',
-  PRIMARY KEY (`question_id`,`tag`)
+  PRIMARY KEY (`site`,`question_id`,`tag`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -37,16 +38,25 @@ DROP TABLE IF EXISTS `questions`;
 /*!40101 SET @saved_cs_client     = @@character_set_client */;
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE `questions` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
   `question_id` int(11) NOT NULL,
   `question_title` varchar(100) DEFAULT NULL,
   `question_url` varchar(200) DEFAULT NULL,
-  `state` varchar(10) DEFAULT 'unanswered',
+  `state` varchar(50) NOT NULL,
   `user` int(11) DEFAULT '0',
   `body` varchar(1000) DEFAULT NULL,
   `creation_date` int(11) DEFAULT NULL,
   `time_updated` int(11) DEFAULT NULL,
-  PRIMARY KEY (`question_id`),
-  UNIQUE KEY `question_id` (`question_id`)
+  `state_reason` varchar(255) DEFAULT NULL,
+  `upstream_changed` int(11) DEFAULT NULL,
+  `upstream_status` varchar(20) NOT NULL DEFAULT 'open',
+  `upstream_reason` varchar(255) DEFAULT NULL,
+  `hidden` tinyint(1) NOT NULL DEFAULT '0',
+  `duplicate_of` int(11) DEFAULT NULL,
+  `relevance` int(11) DEFAULT NULL,
+  `relevance_reasons` varchar(1000) DEFAULT NULL,
+  `answer_id` int(11) DEFAULT NULL,
+  PRIMARY KEY (`site`,`question_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8 STATS_PERSISTENT=1 STATS_AUTO_RECALC=1;
 /*!40101 SET character_set_client = @saved_cs_client */;
 /*!50003 SET @saved_cs_client      = @@character_set_client */ ;
@@ -121,9 +131,277 @@ CREATE TABLE `user` (
   `name` varchar(255) DEFAULT NULL,
   `pic` varchar(255) DEFAULT NULL,
   `link` varchar(255) DEFAULT NULL,
+  `access_token` varchar(512) DEFAULT NULL,
   PRIMARY KEY (`id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `watch`
+--
+
+DROP TABLE IF EXISTS `watch`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `watch` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `tags` varchar(1000) DEFAULT NULL,
+  `nottagged` varchar(1000) DEFAULT NULL,
+  `title` varchar(255) DEFAULT NULL,
+  `body` varchar(255) DEFAULT NULL,
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `active` tinyint(1) NOT NULL DEFAULT '1',
+  `threshold` int(11) NOT NULL DEFAULT '3',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `watch`
+--
+
+LOCK TABLES `watch` WRITE;
+/*!40000 ALTER TABLE `watch` DISABLE KEYS */;
+INSERT INTO `watch` VALUES (1,'Places API','google-places-api;google-places',NULL,'places api','places api','stackoverflow',1,3);
+/*!40000 ALTER TABLE `watch` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `question_watch`
+--
+
+DROP TABLE IF EXISTS `question_watch`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `question_watch` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `question_id` int(11) NOT NULL,
+  `watch_id` int(11) NOT NULL,
+  PRIMARY KEY (`site`,`question_id`,`watch_id`),
+  KEY `watch_id` (`watch_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `sync_checkpoint`
+--
+
+DROP TABLE IF EXISTS `sync_checkpoint`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `sync_checkpoint` (
+  `query_key` char(40) NOT NULL,
+  `query` varchar(2000) DEFAULT NULL,
+  `from_date` int(11) NOT NULL,
+  `to_date` int(11) NOT NULL,
+  `page` int(11) NOT NULL DEFAULT '1',
+  `time_updated` int(11) DEFAULT NULL,
+  PRIMARY KEY (`query_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `answers`
+--
+
+DROP TABLE IF EXISTS `answers`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `answers` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `answer_id` int(11) NOT NULL,
+  `question_id` int(11) NOT NULL,
+  `user_id` int(11) DEFAULT NULL,
+  `user_name` varchar(255) DEFAULT NULL,
+  `creation_date` int(11) DEFAULT NULL,
+  `score` int(11) DEFAULT '0',
+  `is_accepted` tinyint(1) DEFAULT '0',
+  `accepted_date` int(11) DEFAULT NULL,
+  PRIMARY KEY (`site`,`answer_id`),
+  KEY `question_id` (`site`,`question_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `transition_rule`
+--
+
+DROP TABLE IF EXISTS `transition_rule`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `transition_rule` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `event` varchar(50) NOT NULL,
+  `from_states` varchar(500) NOT NULL,
+  `to_state` varchar(50) NOT NULL,
+  `active` tinyint(1) NOT NULL DEFAULT '1',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Dumping data for table `transition_rule`
+--
+
+LOCK TABLES `transition_rule` WRITE;
+/*!40000 ALTER TABLE `transition_rule` DISABLE KEYS */;
+INSERT INTO `transition_rule` VALUES (1,'Team member answered','team_answer','unanswered;pending;updating;needs-repro;escalated;community','answered',1),(2,'Community answered','community_accepted','unanswered','community',1);
+/*!40000 ALTER TABLE `transition_rule` ENABLE KEYS */;
+UNLOCK TABLES;
+
+--
+-- Table structure for table `question_history`
+--
+
+DROP TABLE IF EXISTS `question_history`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `question_history` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `question_id` int(11) NOT NULL,
+  `from_state` varchar(50) DEFAULT NULL,
+  `to_state` varchar(50) NOT NULL,
+  `user_id` int(11) DEFAULT '0',
+  `rule_id` int(11) DEFAULT '0',
+  `reason` varchar(255) DEFAULT NULL,
+  `comment` varchar(500) DEFAULT NULL,
+  `time` int(11) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `question_id` (`site`,`question_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `sync_watermark`
+--
+
+DROP TABLE IF EXISTS `sync_watermark`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `sync_watermark` (
+  `name` varchar(255) NOT NULL,
+  `watermark` int(11) NOT NULL,
+  `claimed_by` varchar(255) DEFAULT NULL,
+  `claimed_until` int(11) DEFAULT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `question_snapshot`
+--
+
+DROP TABLE IF EXISTS `question_snapshot`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `question_snapshot` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `question_id` int(11) NOT NULL,
+  `time` int(11) NOT NULL,
+  `score` int(11) NOT NULL DEFAULT '0',
+  `view_count` int(11) NOT NULL DEFAULT '0',
+  `answer_count` int(11) NOT NULL DEFAULT '0',
+  `favorite_count` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`site`,`question_id`,`time`),
+  KEY `time` (`time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `tag_family`
+--
+
+DROP TABLE IF EXISTS `tag_family`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `tag_family` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `tag` varchar(255) NOT NULL,
+  `family` varchar(255) NOT NULL,
+  `source` varchar(20) NOT NULL DEFAULT 'synonym',
+  PRIMARY KEY (`site`,`tag`),
+  KEY `family` (`site`,`family`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `api_filter`
+--
+
+DROP TABLE IF EXISTS `api_filter`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `api_filter` (
+  `name` varchar(255) NOT NULL,
+  `fields` varchar(2000) NOT NULL,
+  `filter` varchar(255) NOT NULL,
+  `time_created` int(11) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `session`
+--
+
+DROP TABLE IF EXISTS `session`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `session` (
+  `id` char(64) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `created` int(11) NOT NULL,
+  `expires` int(11) NOT NULL,
+  `revoked` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `assignment`
+--
+
+DROP TABLE IF EXISTS `assignment`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `assignment` (
+  `site` varchar(255) NOT NULL,
+  `question_id` int(11) NOT NULL,
+  `assignee` int(11) NOT NULL,
+  `assigned_by` int(11) NOT NULL,
+  `assigned_at` int(11) NOT NULL,
+  `due_date` int(11) DEFAULT NULL,
+  PRIMARY KEY (`site`,`question_id`),
+  KEY `assignee` (`assignee`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `question_note`
+--
+
+DROP TABLE IF EXISTS `question_note`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `question_note` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `question_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `body` text NOT NULL,
+  `created` int(11) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `question_id` (`site`,`question_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+--
+-- Table structure for table `user_account`
+--
+
+DROP TABLE IF EXISTS `user_account`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `user_account` (
+  `site` varchar(255) NOT NULL DEFAULT 'stackoverflow',
+  `account_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  PRIMARY KEY (`site`,`account_id`),
+  KEY `user_id` (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
 /*!40103 SET TIME_ZONE=@OLD_TIME_ZONE */;
 
 /*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
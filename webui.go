@@ -6,13 +6,16 @@ package webui
 
 import (
 	"backend"
+	"dataCollect"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"os"
 
 	"net/http"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -27,7 +30,7 @@ import (
 )
 
 // Functions for sorting
-type byCreationDate []stackongo.Question
+type byCreationDate []question
 type ByDisplayName []userData
 
 func (a byCreationDate) Len() int           { return len(a) }
@@ -40,14 +43,41 @@ func (a ByDisplayName) Less(i, j int) bool {
 	return a[i].User_info.Display_name < a[j].User_info.Display_name
 }
 
+// A question read from the db, along with the StackExchange site it was asked on
+type question struct {
+	stackongo.Question
+	Site            string
+	Status          string               // Status of the question on StackExchange, eg. open or closed
+	StatusReason    string               // Why the question has its status, such as the reason it was closed
+	UpstreamChanged int64                // When the question's edits on StackExchange were last pulled in, or 0 if never
+	Hidden          bool                 // Whether the question is hidden from the default views
+	DuplicateOf     int                  // Id of the question on the same site this was closed as a duplicate of, or 0
+	Duplicates      []question           // Questions closed as duplicates of this one
+	Relevance       *backend.Relevance   // How relevant the question is to the watches that found it, or nil if not scored
+	PostedAnswer    int                  // Id of the answer the team posted from the tracker, or 0
+	Assignment      *backend.Assignment  // Who the question is assigned to, or nil if it is unassigned
+	History         []backend.Transition // Changes of the question's state, oldest first
+	Notes           []backend.Note       // The team's notes on the question, oldest first, only shown to team members
+}
+
 // Reply to send to main template
 type genReply struct {
-	Wrapper    *stackongo.Questions   // Information about the query
-	Caches     []cacheInfo            // Slice of the 4 caches (Unanswered, Answered, Pending, Updating)
-	User       stackongo.User         // Information on the current user
-	Qns        map[int]stackongo.User // Map of users by question ids
+	Wrapper    *stackongo.Questions      // Information about the query
+	Caches     []cacheInfo               // Caches of each state in the workflow, in the order of their tabs
+	User       stackongo.User            // Information on the current user
+	Qns        map[string]stackongo.User // Map of users by question keys
+	Reasons    map[string]string         // Why questions were moved automatically, by question keys
 	UpdateTime int64
-	Query      []string // String array holding query and query type (tag vs user)
+	Query      []string         // String array holding query and query type (tag vs user)
+	Team       []stackongo.User // Team members questions can be assigned to
+	CSRF       string           // Token the page's forms post back, or "" for guests
+}
+
+// The question or tag whose metrics are charted
+type metricsQuery struct {
+	Site string
+	ID   int
+	Tag  string
 }
 
 // Generic reply to send to other templates
@@ -57,34 +87,43 @@ type queryReply struct {
 	Page       int
 	LastPage   int
 	Data       interface{}
+	CSRF       string // Token the page's forms post back, or "" for guests
 }
 
 // Info on the various caches
 type cacheInfo struct {
-	CacheType string               // "unanswered"/"answered"/"pending"/"updating"
-	Questions []stackongo.Question // list of questions
-	Info      string               // blurb about the cache
+	CacheType   string          // Name of the state, eg. "unanswered"
+	Label       string          // Name of the state shown on its tab
+	Questions   []question      // list of questions
+	Info        string          // blurb about the cache
+	Owned       bool            // Whether questions in the cache belong to a team member
+	Action      string          // State the one click button moves questions to, or "" for none
+	ActionLabel string          // Label of the one click button
+	Menu        []backend.State // Other states questions can be moved to
 }
 
 // Data struct with SO information, caches, user information
 type webData struct {
-	Wrapper   *stackongo.Questions            // Request information
-	Caches    map[string][]stackongo.Question // Caches by question states
-	Qns       map[int]stackongo.User          // Map of users by question ids
-	Users     map[int]userData                // Map of users by user ids
-	CacheLock sync.Mutex                      // For multithreading, will use to avoid updating cache and serving cache at the same time
+	Wrapper   *stackongo.Questions      // Request information
+	Caches    map[string][]question     // Caches by question states
+	Qns       map[string]stackongo.User // Map of users by question keys
+	Reasons   map[string]string         // Why questions were moved automatically, by question keys
+	Users     map[int]userData          // Map of users by user ids
+	Team      []stackongo.User          // Team members questions can be assigned to, sorted by name
+	CacheLock sync.Mutex                // For multithreading, will use to avoid updating cache and serving cache at the same time
 }
 
 // User information and the user's caches
 type userData struct {
-	User_info stackongo.User                  // SE user info
-	Caches    map[string][]stackongo.Question // Questions modified by user sorted into cacheTypes
+	User_info stackongo.User        // SE user info
+	Caches    map[string][]question // Questions modified by user sorted into cacheTypes
 }
 
 // Information on tags
 type tagData struct {
-	Tag   string //The actual tag, hyphenated string
-	Count int    //The number of questions with that tag in the db
+	Tag     string   //The actual tag, hyphenated string
+	Count   int      //The number of questions with that tag in the db
+	Members []string //The other tags in the tag's family, which are counted with it
 }
 
 // Simplified user struct
@@ -95,21 +134,39 @@ type userInfo struct {
 	Link string
 }
 
-// Creates an initialised webData struct
+// Creates an initialised webData struct, with a cache for each state in the workflow
 func newWebData() webData {
+	caches := make(map[string][]question)
+	for _, state := range backend.CurrentWorkflow().States {
+		caches[state.Name] = []question{}
+	}
 	return webData{
-		Caches: map[string][]stackongo.Question{
-			"unanswered": []stackongo.Question{},
-			"answered":   []stackongo.Question{},
-			"pending":    []stackongo.Question{},
-			"updating":   []stackongo.Question{},
-		},
-		Qns:   make(map[int]stackongo.User),
-		Users: make(map[int]userData),
+		Caches:  caches,
+		Qns:     make(map[string]stackongo.User),
+		Reasons: make(map[string]string),
+		Users:   make(map[int]userData),
 	}
 }
 
-const timeout = 6 * time.Hour // Time to wait between querying new SE questions
+const timeout = 6 * time.Hour          // Time to wait between querying new SE questions
+const pullLease = 10 * time.Minute     // Time an instance can hold the pull before another may take it over
+const initialPull = 7 * 24 * time.Hour // How far back watches that have never been synced are searched
+
+// Format of the due dates sent by the assignment form
+const dateFormat = "2006-01-02"
+
+// Cache of questions in states that are not in the workflow
+// Not a valid state name, so it cannot clash with a state
+const unknownState = "unknown_state"
+
+// Name of the cookie holding the signed session of a logged in user
+const sessionCookie = "session"
+
+// Form field and header posts carry their session's CSRF token in
+const (
+	csrfField  = "csrf"
+	csrfHeader = "X-CSRF-Token"
+)
 
 // Standard guest user
 var guest = stackongo.User{
@@ -120,12 +177,22 @@ var guest = stackongo.User{
 var db *sql.DB
 var DB_STRING = ""
 
-//Stores the last time the database was read into the cache
-//This is then checked against the update time of the database and determine whether the cache should be updated
-var lastPull = time.Now().Add(-1 * time.Hour * 24 * 7).Unix()
 var recentChangedQns = []string{} // Array of the most recently changed questions
 var mostRecentUpdate int64        // Time of most recent update
 
+// Returns a condition matching rows whose column is in list, with a placeholder for each element, and its arguments
+// An empty list gives a condition that matches nothing
+func inList(column string, list []string) (string, []interface{}) {
+	if len(list) == 0 {
+		return "FALSE", nil
+	}
+	args := make([]interface{}, len(list))
+	for i, s := range list {
+		args[i] = s
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(list)-1) + ")", args
+}
+
 /* --------- Template functions ------------ */
 // Returns timeUnix as a formatted string
 func (r genReply) Timestamp(timeUnix int64) string {
@@ -134,34 +201,101 @@ func (r genReply) Timestamp(timeUnix int64) string {
 	return time.Unix(timeUnix, 0).In(est).Format(timeFormat)
 }
 
+// Returns timeUnix as a date, as a date input takes it
+func (r genReply) Date(timeUnix int64) string {
+	est, _ := time.LoadLocation("Australia/Sydney")
+	return time.Unix(timeUnix, 0).In(est).Format(dateFormat)
+}
+
 // Returns current page + num
 func (r queryReply) PagePlus(num int) int {
 	return r.Page + num
 }
 
+// Returns true if the current user is an admin
+func (r queryReply) IsAdmin() bool {
+	return isAdmin(r.User)
+}
+
+// Returns true if the current user is on the team, and can see its notes
+func (r genReply) IsTeamMember() bool {
+	return isTeamMember(r.User)
+}
+
+// Returns a key identifying the question, as question ids are only unique within a site
+func (q question) Key() string {
+	return q.Site + "_" + strconv.Itoa(q.Question_id)
+}
+
+// Returns a link to the question this was closed as a duplicate of, on the same host as the question's own link
+func (q question) DuplicateOfLink() string {
+	link, err := url.Parse(q.Link)
+	if err != nil {
+		return ""
+	}
+	return link.Scheme + "://" + link.Host + "/questions/" + strconv.Itoa(q.DuplicateOf)
+}
+
 //The app engine will run its own main function and imports this code as a package
 //So no main needs to be defined
 //All routes go in to init
 func init() {
 	recentChangedQns = []string{}
-	lastPull = time.Now().Add(-1 * time.Hour * 24 * 7).Unix()
+
+	// Reading the states questions move through
+	if err := backend.LoadWorkflow("workflow.json"); err != nil {
+		panic(err)
+	}
+
+	// Reading the secret sessions are signed with, which every instance has to share
+	if err := backend.LoadSessionSecret(); err != nil {
+		panic(err)
+	}
 
 	// Initialising stackongo session
 	backend.NewSession()
 
 	// Handlers for pages
 	http.HandleFunc("/login", authHandler)
+	http.HandleFunc("/logout", handler)
+	http.HandleFunc("/revokeSessions", handler)
 	http.HandleFunc("/", handler)
 	http.HandleFunc("/tag", handler)
+	http.HandleFunc("/site", handler)
+	http.HandleFunc("/hidden", handler)
+	http.HandleFunc("/metrics", handler)
+	http.HandleFunc("/snapshots", handler)
+	http.HandleFunc("/restoreQuestion", handler)
+	http.HandleFunc("/post", handler)
+	http.HandleFunc("/assign", handler)
+	http.HandleFunc("/addNote", handler)
+	http.HandleFunc("/assigned", handler)
 	http.HandleFunc("/user", handler)
 	http.HandleFunc("/viewTags", handler)
+	http.HandleFunc("/editTagFamily", handler)
 	http.HandleFunc("/viewUsers", handler)
+	http.HandleFunc("/watch", handler)
+	http.HandleFunc("/viewWatches", handler)
+	http.HandleFunc("/editWatch", handler)
+	http.HandleFunc("/editRule", handler)
 	http.HandleFunc("/dbUpdated", updateHandler)
 	http.HandleFunc("/search", handler)
 	http.HandleFunc("/addQuestion", handler)
 	http.HandleFunc("/pullNewQn", handler)
 }
 
+// Returns true if user is an admin
+// Admins are listed by their StackExchange user id in the STACKTRACKER_ADMINS environment variable
+func isAdmin(user stackongo.User) bool {
+	return user.User_id != 0 && backend.IsAdmin(user.User_id)
+}
+
+// Returns true if user is on the team, and can read and add the team's notes
+// Members are listed by their StackExchange user id in the STACKTRACKER_TEAM environment variable, and admins are members too
+func isTeamMember(user stackongo.User) bool {
+	return user.User_id != 0 && backend.IsTeamMember(user.User_id)
+}
+
 func checkForDBConnection() bool {
 	return !(DB_STRING == "")
 }
@@ -232,13 +366,28 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		newQnHandler(w, r, ctx)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/snapshots") {
+		snapshotsHandler(w, r, ctx)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/logout") {
+		logoutHandler(w, r, ctx)
+		return
+	}
 
 	// Pull any new questions added to StackOverflow
-	lastPull = updateDB(db, ctx, lastPull)
+	updateDB(db, ctx)
 
 	// Get the current user
 	user := getUser(w, r, ctx)
 
+	// Posts change things, so they must carry the token of the session they were made in
+	if r.Method == "POST" && !validCSRF(r) {
+		log.Warningf(ctx, "Post to %v without a valid CSRF token", r.URL.Path)
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
 	// Collect page number
 	pageNum, _ := strconv.Atoi(r.FormValue("page"))
 	if pageNum == 0 {
@@ -250,14 +399,25 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	if cookie != nil && cookie.Value == "true" {
 		// Update the cache based on the form values sent in the request
 		updateTime, err := updatingCache_User(ctx, r, user)
+
+		// Removing the cookie
+		http.SetCookie(w, &http.Cookie{Name: "submitting", Value: ""})
+
+		// Someone else moved the question first, so tell the client what it is now instead of the page
+		if conflict, ok := err.(*backend.ConflictError); ok {
+			log.Infof(ctx, "%v", conflict.Error())
+			conflictHandler(w, r, ctx, conflict)
+			return
+		}
+		if err == backend.ErrTransitionNotAllowed {
+			errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
 			log.Errorf(ctx, "Error updating cache: %v", err.Error())
 		} else {
 			mostRecentUpdate = updateTime
 		}
-
-		// Removing the cookie
-		http.SetCookie(w, &http.Cookie{Name: "submitting", Value: ""})
 	}
 
 	// Send to valid subpages
@@ -279,17 +439,45 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// WriteResponse creates a new response with the various caches
-		if err := page.Execute(w, writeResponse(user, data, pageNum, pageQuery)); err != nil {
+		if err := page.Execute(w, writeResponse(user, data, pageNum, pageQuery, csrfToken(r))); err != nil {
 			log.Errorf(ctx, "%v", err.Error())
 		}
 	} else if strings.HasPrefix(r.URL.Path, "/tag") && r.FormValue("tagSearch") != "" {
 		tagHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/site") && r.FormValue("site") != "" {
+		siteHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/hidden") {
+		hiddenHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/metrics") {
+		metricsHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/restoreQuestion") {
+		restoreQuestionHandler(w, r, ctx, user)
+	} else if strings.HasPrefix(r.URL.Path, "/post") {
+		postHandler(w, r, ctx, user)
+	} else if strings.HasPrefix(r.URL.Path, "/addNote") {
+		addNoteHandler(w, r, ctx, user)
+	} else if strings.HasPrefix(r.URL.Path, "/assigned") {
+		assignedHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/assign") {
+		assignHandler(w, r, ctx, user)
 	} else if strings.HasPrefix(r.URL.Path, "/user") {
 		userHandler(w, r, ctx, pageNum, user)
 	} else if strings.HasPrefix(r.URL.Path, "/viewTags") {
 		viewTagsHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/editTagFamily") {
+		editTagFamilyHandler(w, r, ctx, user)
+	} else if strings.HasPrefix(r.URL.Path, "/revokeSessions") {
+		revokeSessionsHandler(w, r, ctx, user)
 	} else if strings.HasPrefix(r.URL.Path, "/viewUsers") {
 		viewUsersHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/watch") {
+		watchHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/viewWatches") {
+		viewWatchesHandler(w, r, ctx, pageNum, user)
+	} else if strings.HasPrefix(r.URL.Path, "/editWatch") {
+		editWatchHandler(w, r, ctx, user)
+	} else if strings.HasPrefix(r.URL.Path, "/editRule") {
+		editRuleHandler(w, r, ctx, user)
 	} else if strings.HasPrefix(r.URL.Path, "/search") {
 		searchHandler(w, r, ctx, pageNum, user)
 	} else if strings.HasPrefix(r.URL.Path, "/addQuestion") {
@@ -301,32 +489,52 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Responds to a state change that lost to another, with the state and owner the question has now as JSON
+func conflictHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, conflict *backend.ConflictError) {
+	body, err := json.Marshal(struct {
+		Message string
+		*backend.ConflictError
+	}{conflict.Error(), conflict})
+	if err != nil {
+		log.Errorf(ctx, "Marshaling failed: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	w.Write(body)
+}
+
 // Handler for adding new question page
 func addQuestionHandler(w http.ResponseWriter, r *http.Request, ctx context.Context,
 	pageNum int, user stackongo.User) {
 	page := template.Must(template.ParseFiles("public/addQuestion.html"))
-	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, nil}); err != nil {
+	// The page builds each question's buttons from the workflow
+	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, backend.CurrentWorkflow(), csrfToken(r)}); err != nil {
 		log.Warningf(ctx, "%v", err.Error())
 	}
 }
 
-// Handler for pulling questions from Stack Overflow manually, based on a given ID
-// Request is parsed to find the supplied ID
+// Handler for pulling questions from Stack Overflow manually, based on a given site and ID
+// Request is parsed to find the supplied site and ID, the site defaulting to StackOverflow
 // A check is completed to see if the question is already in the system
 // If so, it retrieves that question, and returns it to be viewed, along with a message
 // Makes a new backend request to retrieve new questions
 // Parses the returned data into a new page, which can be inserted into the template.
 func newQnHandler(w http.ResponseWriter, r *http.Request, ctx context.Context) {
 	id, _ := strconv.Atoi(r.FormValue("id"))
+	site := backend.SiteName(r.FormValue("site"))
 
-	res, err := backend.CheckForExistingQuestion(db, id)
+	res, err := backend.CheckForExistingQuestion(db, site, id)
 	if err != nil {
 		log.Infof(ctx, "QUERY FAILED, %v", err)
 	}
 
 	if res == 1 {
 
-		existingQn := backend.PullQnByID(db, ctx, id)
+		// Notes are kept from anyone not on the team
+		user := getUser(w, r, ctx)
+		existingQn := backend.PullQnByID(db, ctx, site, id, isTeamMember(user))
 		if err != nil {
 			log.Warningf(ctx, err.Error())
 		}
@@ -334,12 +542,12 @@ func newQnHandler(w http.ResponseWriter, r *http.Request, ctx context.Context) {
 	} else {
 
 		intArray := []int{id}
-		questions, err := backend.GetQuestions(ctx, intArray)
+		questions, err := backend.GetQuestions(ctx, site, intArray)
 		if err != nil {
 			log.Warningf(ctx, err.Error())
-		} else {
+		} else if len(questions.Items) > 0 {
 			questions.Items[0].Body = backend.StripTags(questions.Items[0].Body)
-			qnJson, err := json.Marshal(questions.Items[0])
+			qnJson, err := json.Marshal(question{Question: questions.Items[0], Site: site})
 			if err != nil {
 				log.Warningf(ctx, err.Error())
 			}
@@ -363,19 +571,19 @@ func addNewQuestionToDatabaseHandler(w http.ResponseWriter, r *http.Request, ctx
 		log.Infof(ctx, "%v", err)
 	}
 	m := f.(map[string]interface{})
-	question := m["Question"]
+	newQuestion := m["Question"]
 	state := m["State"]
 	if err != nil {
 		log.Infof(ctx, "%v", err)
 	}
-	var qn stackongo.Question
-	json.Unmarshal([]byte(question.(string)), &qn)
+	var qn question
+	json.Unmarshal([]byte(newQuestion.(string)), &qn)
 	log.Infof(ctx, "%v", qn)
 
 	user := getUser(w, r, ctx)
 	log.Infof(ctx, "%v", user.User_id)
 
-	if err := backend.AddSingleQuestion(db, qn, state.(string), user.User_id); err != nil {
+	if err := backend.AddSingleQuestion(db, backend.SiteName(qn.Site), qn.Question, state.(string), user.User_id); err != nil {
 		log.Warningf(ctx, "Error adding new question to db:\t", err)
 	}
 	backend.UpdateTableTimes(db, ctx, "question")
@@ -386,15 +594,24 @@ func addNewQuestionToDatabaseHandler(w http.ResponseWriter, r *http.Request, ctx
 func searchHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
 
 	search := r.FormValue("search")
-
-	query := "questions.question_id LIKE '" + search + "'" + // By question id
-		" OR questions.question_url LIKE '" + search + "'" + // By url
-		" OR questions.question_title LIKE '%" + search + "%'" + // By part of title
-		" OR questions.body LIKE '%" + search + "%'" + // By part of body
-		" OR (questions.user LIKE'" + search + "' AND questions.state!='unanswered')" + // By Owner id
-		" OR (user.name LIKE '%" + search + "%' AND questions.state!='unanswered')" + // By Owner display name
-		" OR questions.question_id IN (SELECT question_id FROM question_tag WHERE tag like '" + search + "')" // By tags
-	tempData, updateTime, err := readFromDb(ctx, query)
+	part := "%" + search + "%"
+	// Owners are only matched in the states questions belong to them in
+	owned, ownedArgs := inList("questions.state", backend.CurrentWorkflow().OwnedStates())
+
+	query := "questions.question_id LIKE ?" + // By question id
+		" OR questions.site LIKE ?" + // By site
+		" OR questions.question_url LIKE ?" + // By url
+		" OR questions.question_title LIKE ?" + // By part of title
+		" OR questions.body LIKE ?" + // By part of body
+		" OR (questions.user LIKE ? AND " + owned + ")" + // By Owner id
+		" OR (user.name LIKE ? AND " + owned + ")" + // By Owner display name
+		" OR (questions.site, questions.question_id) IN (SELECT site, question_id FROM question_tag WHERE tag LIKE ?)" // By tags
+	args := []interface{}{search, search, search, part, part, search}
+	args = append(args, ownedArgs...)
+	args = append(args, part)
+	args = append(args, ownedArgs...)
+	args = append(args, search)
+	tempData, updateTime, err := readFromDb(ctx, query, args...)
 	if err != nil {
 		log.Errorf(ctx, "Error reading from db: %v", err.Error())
 	} else {
@@ -408,7 +625,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request, ctx context.Context,
 		search,
 	}
 
-	if err := page.Execute(w, writeResponse(user, tempData, pageNum, pageQuery)); err != nil {
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, pageQuery, csrfToken(r))); err != nil {
 		log.Errorf(ctx, "%v", err.Error())
 	}
 
@@ -419,8 +636,12 @@ func tagHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pag
 	// Collect query
 	tag := r.FormValue("tagSearch")
 
-	query := "questions.question_id IN (SELECT question_id FROM question_tag WHERE tag like '" + tag + "')" // By tags
-	tempData, updateTime, err := readFromDb(ctx, query)
+	// Questions with any tag in the family of a tag matching the search, which can use LIKE wildcards, are found
+	query := "(questions.site, questions.question_id) IN (SELECT question_tag.site, question_tag.question_id FROM question_tag " +
+		"LEFT JOIN tag_family ON question_tag.site=tag_family.site AND question_tag.tag=tag_family.tag " +
+		"WHERE COALESCE(tag_family.family, question_tag.tag) LIKE ? " + // By family, or tag if it has no family
+		"OR COALESCE(tag_family.family, question_tag.tag) IN (SELECT family FROM tag_family AS member WHERE member.site=question_tag.site AND member.tag LIKE ?))" // By any other tag in the family on the same site
+	tempData, updateTime, err := readFromDb(ctx, query, tag, tag)
 	if err != nil {
 		log.Errorf(ctx, "Error reading from db: %v", err.Error())
 	} else {
@@ -432,18 +653,443 @@ func tagHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pag
 		"tag",
 		tag,
 	}
-	if err := page.Execute(w, writeResponse(user, tempData, pageNum, tagQuery)); err != nil {
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, tagQuery, csrfToken(r))); err != nil {
+		log.Warningf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler to find all questions asked on a StackExchange site
+func siteHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	// Collect query
+	site := backend.SiteName(r.FormValue("site"))
+
+	tempData, updateTime, err := readFromDb(ctx, "questions.site=?", site)
+	if err != nil {
+		log.Errorf(ctx, "Error reading from db: %v", err.Error())
+	} else {
+		mostRecentUpdate = updateTime
+	}
+
+	page := template.Must(template.ParseFiles("public/template.html"))
+	var siteQuery = []string{
+		"site",
+		site,
+	}
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, siteQuery, csrfToken(r))); err != nil {
+		log.Warningf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler to find the questions hidden because they were closed, deleted, migrated or locked on StackExchange
+func hiddenHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	tempData, updateTime, err := readQuestionsFromDb(ctx, "", true)
+	if err != nil {
+		log.Errorf(ctx, "Error reading from db: %v", err.Error())
+	} else {
+		mostRecentUpdate = updateTime
+	}
+
+	page := template.Must(template.ParseFiles("public/template.html"))
+	var hiddenQuery = []string{
+		"hidden",
+		"Hidden questions",
+	}
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, hiddenQuery, csrfToken(r))); err != nil {
+		log.Warningf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler for showing a hidden question again from the restore button on the hidden questions page
+// Redirects back to the hidden questions page once restored
+func restoreQuestionHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if user.User_id == 0 {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	// The question is sent by its key, site_id
+	site, id, err := parseQuestionKey(r.PostFormValue("question"))
+	if err != nil {
+		errorHandler(w, r, ctx, http.StatusBadRequest, "")
+		return
+	}
+	if err := backend.RestoreQuestion(db, ctx, site, id); err != nil {
+		log.Errorf(ctx, "Error restoring question: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/hidden", http.StatusSeeOther)
+}
+
+// Returns the site and id of a question from its key, site_id
+func parseQuestionKey(key string) (string, int, error) {
+	sep := strings.LastIndex(key, "_")
+	if sep < 0 {
+		return "", 0, fmt.Errorf("Invalid question key %q", key)
+	}
+	id, err := strconv.Atoi(key[sep+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid question key %q", key)
+	}
+	return backend.SiteName(key[:sep]), id, nil
+}
+
+// Handler for posting an answer or comment written in the tracker to StackExchange, as the current user
+// The button pressed sends the kind of post and the question's key as kind_site_id, and the body is sent as body_site_id.
+// Redirects back to the page posted from
+func postHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if user.User_id == 0 {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	post := strings.SplitN(r.PostFormValue("post"), "_", 2)
+	if len(post) != 2 {
+		errorHandler(w, r, ctx, http.StatusBadRequest, "")
+		return
+	}
+	site, id, err := parseQuestionKey(post[1])
+	body := strings.TrimSpace(r.PostFormValue("body_" + post[1]))
+	if err != nil || body == "" {
+		errorHandler(w, r, ctx, http.StatusBadRequest, "")
+		return
+	}
+
+	switch post[0] {
+	case "answer":
+		_, err = backend.PostAnswer(db, ctx, site, id, user.User_id, body)
+	case "comment":
+		_, err = backend.PostComment(db, ctx, site, id, user.User_id, body)
+	default:
+		errorHandler(w, r, ctx, http.StatusBadRequest, "")
+		return
+	}
+	if err == backend.ErrNoAccessToken {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	} else if err == backend.ErrTransitionNotAllowed {
+		errorHandler(w, r, ctx, http.StatusBadRequest, "questions in this state cannot be answered from the tracker")
+		return
+	} else if dataCollect.KindOf(err) == dataCollect.ErrBadParams {
+		// Posts StackExchange refuses, such as answers that are too short, are the writer's to fix
+		errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Errorf(ctx, "Error posting %v: %v", post[0], err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	back := r.Referer()
+	if back == "" {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Handler for assigning a question to a team member, with an optional due date, from the form under each question
+// The button pressed sends the question's key, the assignee is sent as assignee_site_id and the due date as due_site_id.
+// Questions sent without an assignee are unassigned. Redirects back to the page assigned from
+func assignHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if user.User_id == 0 {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	key := r.PostFormValue("question")
+	site, id, err := parseQuestionKey(key)
+	if err != nil {
+		errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.PostFormValue("assignee_"+key) == "" {
+		err = backend.UnassignQuestion(db, ctx, site, id)
+	} else {
+		assignee, convErr := strconv.Atoi(r.PostFormValue("assignee_" + key))
+		if convErr != nil {
+			errorHandler(w, r, ctx, http.StatusBadRequest, "Invalid assignee")
+			return
+		}
+		// Questions are due by the end of their due date
+		var due time.Time
+		if dueDate := r.PostFormValue("due_" + key); dueDate != "" {
+			est, _ := time.LoadLocation("Australia/Sydney")
+			day, parseErr := time.ParseInLocation(dateFormat, dueDate, est)
+			if parseErr != nil {
+				errorHandler(w, r, ctx, http.StatusBadRequest, "Invalid due date")
+				return
+			}
+			due = day.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		err = backend.AssignQuestion(db, ctx, site, id, assignee, user.User_id, due)
+	}
+	if err == backend.ErrUnknownAssignee || err == backend.ErrUntrackedQuestion {
+		errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Errorf(ctx, "Error assigning question: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	back := r.Referer()
+	if back == "" {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Handler for adding a note to a question from the form under it
+// The button pressed sends the question's key, and the note is sent as note_site_id.
+// Redirects back to the page the note was added from
+func addNoteHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if !isTeamMember(user) {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	key := r.PostFormValue("question")
+	site, id, err := parseQuestionKey(key)
+	if err != nil {
+		errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = backend.AddNote(db, ctx, site, id, user.User_id, r.PostFormValue("note_"+key))
+	if err == backend.ErrEmptyNote {
+		errorHandler(w, r, ctx, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Errorf(ctx, "Error adding note: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	back := r.Referer()
+	if back == "" {
+		back = "/"
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Handler for the questions assigned to the current user, in every state
+func assignedHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	if user.User_id == 0 {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	tempData, updateTime, err := readFromDb(ctx, "assignment.assignee="+strconv.Itoa(user.User_id))
+	if err != nil {
+		log.Errorf(ctx, "Error reading from db: %v", err.Error())
+	} else {
+		mostRecentUpdate = updateTime
+	}
+
+	page := template.Must(template.ParseFiles("public/template.html"))
+	var assignedQuery = []string{
+		"assigned",
+		"Assigned to " + user.Display_name,
+	}
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, assignedQuery, csrfToken(r))); err != nil {
+		log.Warningf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler for the page charting how a question's metrics, or the totals for a tag, change over time
+// Questions are given by site and id, tags by tag. The page reads the snapshots from /snapshots
+func metricsHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	query := metricsQuery{Tag: r.FormValue("tag")}
+	if query.Tag == "" {
+		query.Site = backend.SiteName(r.FormValue("site"))
+		query.ID, _ = strconv.Atoi(r.FormValue("id"))
+		if query.ID == 0 {
+			errorHandler(w, r, ctx, http.StatusNotFound, "")
+			return
+		}
+	}
+
+	page := template.Must(template.ParseFiles("public/metrics.html"))
+	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, query, csrfToken(r)}); err != nil {
+		log.Warningf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler returning the snapshots of a question, or the totals for a tag, as JSON
+// Questions also return the times team members answered them, so charts can mark them
+func snapshotsHandler(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	var reply struct {
+		Snapshots   []backend.Snapshot
+		TeamAnswers []int64
+	}
+	var err error
+	if tag := r.FormValue("tag"); tag != "" {
+		reply.Snapshots, err = backend.ReadTagSnapshots(db, tag)
+	} else {
+		site := backend.SiteName(r.FormValue("site"))
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		reply.Snapshots, err = backend.ReadSnapshots(db, site, id)
+		if err == nil {
+			reply.TeamAnswers, err = backend.TeamAnswerTimes(db, site, id)
+		}
+	}
+	if err != nil {
+		log.Errorf(ctx, "Error reading snapshots: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	body, err := json.Marshal(reply)
+	if err != nil {
+		log.Errorf(ctx, "Marshaling failed: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// Handler to find all questions matched by a watch
+func watchHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	watchID, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		errorHandler(w, r, ctx, http.StatusNotFound, "")
+		return
+	}
+	watches, err := backend.ReadWatches(db, "id="+strconv.Itoa(watchID))
+	if err != nil || len(watches) == 0 {
+		errorHandler(w, r, ctx, http.StatusNotFound, "")
+		return
+	}
+
+	query := "(questions.site, questions.question_id) IN (SELECT site, question_id FROM question_watch WHERE watch_id=" + strconv.Itoa(watchID) + ")"
+	tempData, updateTime, err := readFromDb(ctx, query)
+	if err != nil {
+		log.Errorf(ctx, "Error reading from db: %v", err.Error())
+	} else {
+		mostRecentUpdate = updateTime
+	}
+
+	page := template.Must(template.ParseFiles("public/template.html"))
+	var watchQuery = []string{
+		"watch",
+		watches[0].Name,
+	}
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, watchQuery, csrfToken(r))); err != nil {
 		log.Warningf(ctx, "%v", err.Error())
 	}
 }
 
+// Handler for viewing and editing the watch definitions used to pull new questions
+// and the rules used to move questions automatically
+func viewWatchesHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
+	watches, err := backend.ReadWatches(db, "")
+	if err != nil {
+		log.Errorf(ctx, "Error reading watches: %v", err.Error())
+	}
+	rules, err := backend.ReadTransitionRules(db, "")
+	if err != nil {
+		log.Errorf(ctx, "Error reading transition rules: %v", err.Error())
+	}
+	data := struct {
+		Watches []backend.Watch
+		Rules   []backend.TransitionRule
+		Events  []string
+	}{
+		watches,
+		rules,
+		[]string{backend.EventTeamAnswer, backend.EventCommunityAccepted},
+	}
+
+	page := template.Must(template.ParseFiles("public/viewWatches.html"))
+	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, data, csrfToken(r)}); err != nil {
+		log.Errorf(ctx, "%v", err.Error())
+	}
+}
+
+// Handler for adding or updating a watch from the form on the watches page
+// Only admins can change watches. Tags are entered separated by spaces or semicolons
+// Redirects back to the watches page once saved
+func editWatchHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if !isAdmin(user) {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PostFormValue("id"))
+	threshold, err := strconv.Atoi(r.PostFormValue("threshold"))
+	if err != nil {
+		threshold = backend.DefaultThreshold
+	}
+	watch := backend.Watch{
+		ID:        id,
+		Name:      r.PostFormValue("name"),
+		Tags:      strings.Fields(strings.Replace(r.PostFormValue("tags"), ";", " ", -1)),
+		NotTagged: strings.Fields(strings.Replace(r.PostFormValue("nottagged"), ";", " ", -1)),
+		Title:     strings.TrimSpace(r.PostFormValue("title")),
+		Body:      strings.TrimSpace(r.PostFormValue("body")),
+		Site:      strings.TrimSpace(r.PostFormValue("site")),
+		Active:    r.PostFormValue("active") != "",
+		Threshold: threshold,
+	}
+	if err := backend.SaveWatch(db, ctx, watch); err != nil {
+		log.Errorf(ctx, "Error saving watch: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/viewWatches", http.StatusSeeOther)
+}
+
+// Handler for adding or updating a transition rule from the form on the watches page
+// Only admins can change rules. States are entered separated by spaces or semicolons
+// Redirects back to the watches page once saved
+func editRuleHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if !isAdmin(user) {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PostFormValue("id"))
+	rule := backend.TransitionRule{
+		ID:         id,
+		Name:       r.PostFormValue("name"),
+		Event:      r.PostFormValue("event"),
+		FromStates: strings.Fields(strings.Replace(r.PostFormValue("from_states"), ";", " ", -1)),
+		ToState:    strings.TrimSpace(r.PostFormValue("to_state")),
+		Active:     r.PostFormValue("active") != "",
+	}
+	if err := backend.SaveTransitionRule(db, ctx, rule); err != nil {
+		log.Errorf(ctx, "Error saving transition rule: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/viewWatches", http.StatusSeeOther)
+}
+
+// Handler for putting a tag in a family from the form on the tags page
+// Only admins can change families. Redirects back to the tags page once saved
+func editTagFamilyHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	if !isAdmin(user) {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	site := backend.SiteName(r.PostFormValue("site"))
+	if err := backend.SaveTagFamily(db, ctx, site, r.PostFormValue("tag"), r.PostFormValue("family")); err != nil {
+		log.Errorf(ctx, "Error saving tag family: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/viewTags", http.StatusSeeOther)
+}
+
 // Handler to find all questions answered/being answered by the user in URL
 func userHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pageNum int, user stackongo.User) {
 	userID_string := r.FormValue("id")
 	log.Infof(ctx, "current user id=%s", userID_string)
 
 	// Create a new webData struct
-	tempData, updateTime, err := readFromDb(ctx, "state='unanswered' OR questions.user="+userID_string)
+	tempData, updateTime, err := readFromDb(ctx, "state=? OR questions.user=?", backend.CurrentWorkflow().Initial, userID_string)
 	if err != nil {
 		log.Errorf(ctx, "Error reading from db: %v", err.Error())
 	} else {
@@ -457,7 +1103,7 @@ func userHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, pa
 		tempData.Users[userID_int].User_info.Display_name,
 	}
 	log.Infof(ctx, "Query = %v", Query)
-	if err := page.Execute(w, writeResponse(user, tempData, pageNum, Query)); err != nil {
+	if err := page.Execute(w, writeResponse(user, tempData, pageNum, Query, csrfToken(r))); err != nil {
 		log.Warningf(ctx, "%v", err.Error())
 	}
 }
@@ -492,7 +1138,7 @@ func viewTagsHandler(w http.ResponseWriter, r *http.Request, ctx context.Context
 	if last > len(tagArray) {
 		last = len(tagArray)
 	}
-	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, lastPage, tagArray[first:last]}); err != nil {
+	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, lastPage, tagArray[first:last], csrfToken(r)}); err != nil {
 		log.Warningf(ctx, "%v", err.Error())
 	}
 
@@ -523,30 +1169,49 @@ func viewUsersHandler(w http.ResponseWriter, r *http.Request, ctx context.Contex
 			tempQueryArray = nil
 		}
 	}
+	// Users' questions are counted for each owned state
+	var owned []backend.State
+	for _, state := range backend.CurrentWorkflow().States {
+		if state.Owned {
+			owned = append(owned, state)
+		}
+	}
 	final := struct {
 		User   userData
 		Others [][]userData
+		States []backend.State
 	}{
 		query[user.User_id],
 		queryArray,
+		owned,
 	}
 
 	page := template.Must(template.ParseFiles("public/viewUsers.html"))
-	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, final}); err != nil {
+	if err := page.Execute(w, queryReply{user, mostRecentUpdate, pageNum, 0, final, csrfToken(r)}); err != nil {
 		log.Errorf(ctx, "%v", err.Error())
 	}
 }
 
 
 // Returns the current user requesting the page
+// Users are read from their signed session cookie, or logged in with the code StackExchange redirects back with.
+// Anyone else is the guest user.
 func getUser(w http.ResponseWriter, r *http.Request, ctx context.Context) stackongo.User {
-	// Collect userId from browser cookie
-	username, err := r.Cookie("user_name")
-	if err == nil && username.Value != "" && username.Value != "Guest" {
-		return readUserFromDb(ctx, username.Value)
+	// Collect the user id from the session cookie
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		_, userID, err := backend.ReadUserSession(db, cookie.Value)
+		if err == nil {
+			if user := readUserFromDb(ctx, userID); user.User_id != 0 {
+				return user
+			}
+		} else if err == backend.ErrInvalidSession {
+			clearSessionCookie(w)
+		} else {
+			log.Errorf(ctx, "Error reading session: %v", err.Error())
+		}
 	}
 
-	// If user_id cookie is not set, look for code in url request to collect access token.
+	// If there is no session, look for code in url request to collect access token.
 	// If code is not available, return guest user
 	code := r.FormValue("code")
 	if code == "" {
@@ -571,8 +1236,31 @@ func getUser(w http.ResponseWriter, r *http.Request, ctx context.Context) stacko
 		return guest
 	}
 
-	// Add user to db if not already in
+	// Add user to db if not already in, and keep their access token for acting on their behalf
 	addUserToDB(ctx, user)
+	if err := backend.SaveUserAccounts(db, ctx, user.User_id, user.Account_id); err != nil {
+		log.Warningf(ctx, "Error saving accounts of user %v: %v", user.User_id, err.Error())
+	}
+	if err := backend.SaveAccessToken(db, user.User_id, access_tokens["access_token"]); err != nil {
+		log.Errorf(ctx, "Error saving access token: %v", err.Error())
+	}
+
+	// Start a session, so the user stays logged in on later requests
+	value, expires, err := backend.NewUserSession(db, user.User_id)
+	if err != nil {
+		log.Errorf(ctx, "Error starting session: %v", err.Error())
+		return user
+	}
+	setSessionCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+	// The page for this request is written with the new session's CSRF token
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
 
 	//zhu li do the thing
 	//updateLoginTime(ctx, user)
@@ -580,74 +1268,220 @@ func getUser(w http.ResponseWriter, r *http.Request, ctx context.Context) stacko
 	return user
 }
 
-// Update the database if the lastPullTime is more than 6 hours before the current time
-func updateDB(db *sql.DB, ctx context.Context, lastPullTime int64) int64 {
-	// If the last pull was more than 6 hours ago
-	if lastPull < time.Now().Add(-1*timeout).Unix() {
-		log.Infof(ctx, "Updating database")
+// Returns the CSRF token of the request's session, or "" for guests
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return ""
+	}
+	return backend.CSRFToken(cookie.Value)
+}
 
-		// Remove deleted questions from the database
-		log.Infof(ctx, "Removing deleted questions from db")
-		if err := backend.RemoveDeletedQuestions(db, ctx); err != nil {
-			log.Warningf(ctx, "Error removing deleted questions: %v", err.Error())
-			return lastPullTime
-		}
+// Returns true if a post carries the CSRF token of its session, in its form or headers
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfField)
+	}
+	return backend.ValidCSRFToken(cookie.Value, token)
+}
 
-		// Setting time frame to get new questions.
-		toDate := time.Now()
-		fromDate := time.Unix(lastPull, 0)
+// Sets the session cookie, only sent back on requests from other sites when following a link to the tracker
+// http.Cookie has no SameSite field on the go1 runtime, so the attribute is added to the header by hand.
+func setSessionCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	w.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Lax")
+}
 
-		// Collect new questions from SO
-		questions, err := backend.GetNewQns(fromDate, toDate)
-		if err != nil {
-			log.Warningf(ctx, "Error getting new questions: %v", err.Error())
-			return lastPullTime
+// Removes the session cookie from the browser
+func clearSessionCookie(w http.ResponseWriter) {
+	setSessionCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// Handler for logging out, which revokes the current session
+// Redirects to the home page as the guest user
+func logoutHandler(w http.ResponseWriter, r *http.Request, ctx context.Context) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if id, _, err := backend.ReadUserSession(db, cookie.Value); err == nil {
+			if err := backend.RevokeSession(db, id); err != nil {
+				log.Errorf(ctx, "Error revoking session: %v", err.Error())
+			}
 		}
+	}
+	clearSessionCookie(w)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Handler for revoking every session of a user, logging them out everywhere
+// Users can revoke their own sessions, and admins can revoke anyone's. Redirects back to the users page
+func revokeSessionsHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, user stackongo.User) {
+	target, err := strconv.Atoi(r.PostFormValue("user"))
+	if err != nil || target == 0 {
+		errorHandler(w, r, ctx, http.StatusBadRequest, "")
+		return
+	}
+	if user.User_id == 0 || (target != user.User_id && !isAdmin(user)) {
+		errorHandler(w, r, ctx, http.StatusForbidden, "")
+		return
+	}
+
+	if err := backend.RevokeUserSessions(db, target); err != nil {
+		log.Errorf(ctx, "Error revoking sessions: %v", err.Error())
+		errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if target == user.User_id {
+		clearSessionCookie(w)
+	}
+	http.Redirect(w, r, "/viewUsers", http.StatusSeeOther)
+}
+
+// Update the database if the last pull finished more than 6 hours ago
+// The pull is claimed in the database first, so only one instance syncs at a time
+func updateDB(db *sql.DB, ctx context.Context) {
+	owner := appengine.InstanceID() + "/" + appengine.RequestID(ctx)
+	claimed, err := backend.ClaimPull(db, owner, timeout, pullLease)
+	if err != nil {
+		log.Warningf(ctx, "Error claiming pull: %v", err.Error())
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	finished := pullUpdates(db, ctx)
+	if err := backend.ReleasePull(db, owner, finished); err != nil {
+		log.Warningf(ctx, "Error releasing pull: %v", err.Error())
+	}
+}
+
+// Pulls new questions, answers, edits and statuses from StackExchange
+// Returns true if the pull is done until the next timeout, or false if it should be retried on a later request
+func pullUpdates(db *sql.DB, ctx context.Context) bool {
+	log.Infof(ctx, "Updating database")
+
+	// Mark questions closed, deleted, migrated or locked on SE
+	log.Infof(ctx, "Refreshing question statuses")
+	if err := backend.RefreshQuestionStatuses(db, ctx); err != nil {
+		return syncFailed(ctx, "refreshing question statuses", err)
+	}
+
+	// Seed tag families from the sites' tag synonyms, so watches search whole families
+	if err := backend.RefreshTagFamilies(db, ctx); err != nil {
+		syncFailed(ctx, "refreshing tag families", err)
+	}
+
+	// Watches never synced before search from initialPull ago, the rest carry on from their watermarks
+	toDate := time.Now()
+	fromDate := toDate.Add(-initialPull)
+
+	// Collect new questions matching each active watch from the site it searches
+	watches, err := backend.ActiveWatches(db)
+	if err != nil {
+		log.Warningf(ctx, "Error reading watches: %v", err.Error())
+		return false
+	}
+
+	for _, site := range backend.WatchSites(watches) {
+		// If the pull is stopped part way, the questions collected so far are still added
+		// and the next pull resumes from the saved checkpoints
+		questions, matches, pullErr := backend.GetNewQns(db, ctx, site, watches, fromDate, toDate)
 
 		// Add new questions to database
-		log.Infof(ctx, "Adding new questions to db")
-		if err := backend.AddQuestions(db, ctx, questions); err != nil {
+		log.Infof(ctx, "Adding new questions from %v to db", site)
+		if err := backend.AddWatchedQuestions(db, ctx, site, questions, watches, matches); err != nil {
 			log.Warningf(ctx, "Error adding new questions: %v", err.Error())
-			return lastPullTime
+			return false
+		}
+		if err := backend.AddQuestionWatches(db, ctx, site, matches); err != nil {
+			log.Warningf(ctx, "Error recording question watches: %v", err.Error())
 		}
+		if pullErr != nil {
+			return syncFailed(ctx, "getting new questions from "+site, pullErr)
+		}
+	}
 
-		lastPullTime = time.Now().Unix()
-		log.Infof(ctx, "New questions added")
+	// Update questions edited on SO
+	log.Infof(ctx, "Refreshing edited questions")
+	if err := backend.RefreshEditedQuestions(db, ctx); err != nil {
+		syncFailed(ctx, "refreshing edited questions", err)
 	}
-	return lastPullTime
+
+	// Collect answers for the tracked questions
+	log.Infof(ctx, "Refreshing answers")
+	if err := backend.RefreshAnswers(db, ctx); err != nil {
+		syncFailed(ctx, "refreshing answers", err)
+	}
+
+	// Move questions based on the new answers
+	if err := backend.ApplyTransitionRules(db, ctx); err != nil {
+		log.Warningf(ctx, "Error applying transition rules: %v", err.Error())
+	}
+
+	log.Infof(ctx, "New questions added")
+	return true
+}
+
+// Logs an error from one step of the pull, and returns true if the pull should still be recorded as done
+// Throttling, quota and server errors leave the pull due, so it is retried on a later request
+// once the client's backoff or quota stop has passed.
+// Rejected access tokens and bad parameters will not fix themselves, so an admin is alerted
+// and the pull waits for the usual timeout before trying again.
+func syncFailed(ctx context.Context, step string, err error) bool {
+	switch dataCollect.KindOf(err) {
+	case dataCollect.ErrThrottle, dataCollect.ErrQuota, dataCollect.ErrServer:
+		log.Warningf(ctx, "Error %v, retrying later: %v", step, err.Error())
+		return false
+	case dataCollect.ErrAuth:
+		log.Criticalf(ctx, "Error %v, the access token must be renewed by logging in again: %v", step, err.Error())
+		return true
+	case dataCollect.ErrBadParams:
+		log.Criticalf(ctx, "Error %v, the request needs fixing: %v", step, err.Error())
+		return true
+	}
+	log.Warningf(ctx, "Error %v: %v", step, err.Error())
+	return false
 }
 
 // Write a genReply struct with the inputted Question slices
 // This can call readFromDb() now as a method, most of this is redundant.
-func writeResponse(user stackongo.User, writeData webData, pageNum int, query []string) genReply {
+func writeResponse(user stackongo.User, writeData webData, pageNum int, query []string, csrf string) genReply {
+	// Slices caches and their relevant info, in the order of the workflow's states
+	workflow := backend.CurrentWorkflow()
+	caches := []cacheInfo{}
+	for _, state := range workflow.States {
+		caches = append(caches, cacheInfo{
+			CacheType:   state.Name,
+			Label:       state.Label,
+			Questions:   writeData.Caches[state.Name],
+			Info:        state.Description,
+			Owned:       state.Owned,
+			Action:      state.Action,
+			ActionLabel: state.ActionLabel,
+			Menu:        workflow.Menu(state.Name),
+		})
+	}
+	if unknown := writeData.Caches[unknownState]; len(unknown) > 0 {
+		caches = append(caches, cacheInfo{
+			CacheType: unknownState,
+			Label:     "Unknown state",
+			Questions: unknown,
+			Info:      "These are questions in states that are no longer in the workflow. Add their states back to workflow.json to move them",
+		})
+	}
 	return genReply{
-		Wrapper: writeData.Wrapper, // The global wrapper
-		Caches: []cacheInfo{ // Slices caches and their relevant info
-			cacheInfo{
-				CacheType: "unanswered",
-				Questions: writeData.Caches["unanswered"],
-				Info:      "These are questions that have not yet been answered by the Places API team",
-			},
-			cacheInfo{
-				CacheType: "answered",
-				Questions: writeData.Caches["answered"],
-				Info:      "These are questions that have been answered by the Places API team",
-			},
-			cacheInfo{
-				CacheType: "pending",
-				Questions: writeData.Caches["pending"],
-				Info:      "These are questions that are being answered by the Places API team",
-			},
-			cacheInfo{
-				CacheType: "updating",
-				Questions: writeData.Caches["updating"],
-				Info:      "These are questions that will be answered in the next release",
-			},
-		},
-		User:       user,             // Current user information
-		Qns:        writeData.Qns,    // Map users by questions answered
-		UpdateTime: mostRecentUpdate, // Time of last update
-		Query:      query,            // Current query value
+		Wrapper:    writeData.Wrapper, // The global wrapper
+		Caches:     caches,
+		User:       user,              // Current user information
+		Qns:        writeData.Qns,     // Map users by questions answered
+		Reasons:    writeData.Reasons, // Reasons for automatic changes
+		UpdateTime: mostRecentUpdate,  // Time of last update
+		Query:      query,             // Current query value
+		Team:       writeData.Team,    // Members to assign questions to
+		CSRF:       csrf,              // Token for the page's forms
 	}
 }
 
@@ -661,6 +1495,10 @@ func errorHandler(w http.ResponseWriter, r *http.Request, ctx context.Context, s
 			errorHandler(w, r, ctx, http.StatusInternalServerError, err.Error())
 			return
 		}
+	case http.StatusBadRequest:
+		w.Write([]byte("Bad request: " + err))
+	case http.StatusForbidden:
+		w.Write([]byte("Must be logged in to continue"))
 	case http.StatusInternalServerError:
 		w.Write([]byte("Internal error: " + err))
 	}
@@ -677,14 +1515,15 @@ func contains(slice []string, toFind string) bool {
 }
 
 // Initializes userData struct
+// Questions are only kept for the owned states, as they are the ones questions belong to users in
 func newUser(u stackongo.User) userData {
+	caches := make(map[string][]question)
+	for _, state := range backend.CurrentWorkflow().OwnedStates() {
+		caches[state] = []question{}
+	}
 	return userData{
 		User_info: u,
-		Caches: map[string][]stackongo.Question{
-			"answered": []stackongo.Question{},
-			"pending":  []stackongo.Question{},
-			"updating": []stackongo.Question{},
-		},
+		Caches:    caches,
 	}
 }
 